@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/zavtra-na-rabotu/gophermart/internal/configuration"
 	"github.com/zavtra-na-rabotu/gophermart/internal/db"
 	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
@@ -10,81 +14,355 @@ import (
 	"github.com/zavtra-na-rabotu/gophermart/internal/job"
 	"github.com/zavtra-na-rabotu/gophermart/internal/logger"
 	"github.com/zavtra-na-rabotu/gophermart/internal/middleware"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"github.com/zavtra-na-rabotu/gophermart/internal/notification"
 	"github.com/zavtra-na-rabotu/gophermart/internal/security"
 	"github.com/zavtra-na-rabotu/gophermart/internal/service"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/stringutils"
+	"github.com/zavtra-na-rabotu/gophermart/internal/validation"
 	"go.uber.org/zap"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 )
 
+const (
+	exitCodeOK       = 0
+	exitCodeFailure  = 1
+	exitCodeShutdown = 2
+)
+
+// accrualWebhookTolerance is how far the timestamp of a pushed accrual status may drift from now.
+const accrualWebhookTolerance = 5 * time.Minute
+
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
 	config := configuration.Configure()
 	logger.InitLogger()
+	defer zap.L().Sync()
+
+	err := config.Validate()
+	if err != nil {
+		zap.L().Error("Invalid configuration", zap.Error(err))
+		return exitCodeFailure
+	}
+
+	accessTokenLifetime := time.Duration(config.AccessTokenLifetime) * time.Minute
+	if config.JwtLifetimeHours > 0 {
+		accessTokenLifetime = time.Duration(config.JwtLifetimeHours) * time.Hour
+	}
+	jwtService, err := newJwtService(config, accessTokenLifetime)
+	if err != nil {
+		zap.L().Error("Failed to load JWT keys", zap.Error(err))
+		return exitCodeFailure
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
 	router := chi.NewRouter()
 
 	// Init database
 	dbConnection, err := db.NewDBStorage(config.DatabaseURI)
 	if err != nil {
-		zap.S().Fatal("Failed to connect to database", zap.Error(err))
+		zap.L().Error("Failed to connect to database", zap.Error(err))
+		return exitCodeFailure
 	}
+	defer func() {
+		if err := dbConnection.Close(); err != nil {
+			zap.L().Error("Failed to close database connection", zap.Error(err))
+		}
+	}()
 
 	// Run migrations
 	err = db.RunMigrations(dbConnection)
 	if err != nil {
-		zap.S().Fatal("Failed to run migrations", zap.Error(err))
+		zap.L().Error("Failed to run migrations", zap.Error(err))
+		return exitCodeFailure
 	}
 
 	transactionManager := db.NewTransactionManager(dbConnection)
 
+	userValidator, err := validation.NewUserValidator(validation.UserPolicy{
+		LoginMinLength:        config.LoginMinLength,
+		LoginMaxLength:        config.LoginMaxLength,
+		LoginPattern:          config.LoginPattern,
+		PasswordMinLength:     config.PasswordMinLength,
+		PasswordMinClasses:    config.PasswordMinClasses,
+		BreachedPasswordsFile: config.BreachedPasswordsFile,
+	})
+	if err != nil {
+		zap.L().Error("Failed to build user validator", zap.Error(err))
+		return exitCodeFailure
+	}
+
+	passwords, err := newPasswords(config)
+	if err != nil {
+		zap.L().Error("Failed to build password hasher", zap.Error(err))
+		return exitCodeFailure
+	}
+
 	// Build repositories
 	orderRepository := repository.NewOrderRepository(dbConnection)
 	balanceRepository := repository.NewBalanceRepository(dbConnection)
 	userRepository := repository.NewUserRepository(dbConnection)
 	withdrawalRepository := repository.NewWithdrawalRepository(dbConnection)
+	ledgerRepository := repository.NewLedgerRepository(dbConnection)
+	tokenRepository := repository.NewTokenRepository(dbConnection)
+	accrualEventRepository := repository.NewAccrualEventRepository(dbConnection)
+	loginAttemptRepository := repository.NewLoginAttemptRepository(dbConnection)
+	passwordResetRepository := repository.NewPasswordResetRepository(dbConnection)
 
 	// Build services
-	orderService := service.NewOrderService(transactionManager, orderRepository, balanceRepository)
-	balanceService := service.NewBalanceService(balanceRepository)
-	jwtService := security.NewJwtService([]byte(config.JwtSecret), config.JwtLifetimeHours)
-	userService := service.NewUserService(transactionManager, userRepository, balanceRepository, jwtService)
-	withdrawalService := service.NewWithdrawalService(transactionManager, withdrawalRepository, orderRepository, balanceRepository)
+	orderService := service.NewOrderService(transactionManager, orderRepository, balanceRepository, ledgerRepository, accrualEventRepository)
+	balanceService := service.NewBalanceService(ledgerRepository)
+	tokenService := service.NewTokenService(transactionManager, tokenRepository, userRepository, jwtService, time.Duration(config.RefreshTokenLifetime)*time.Hour)
+	loginThrottle := service.NewLoginThrottle(transactionManager, loginAttemptRepository, service.LoginThrottleOptions{
+		FreeAttempts:         config.LoginFreeAttempts,
+		DelayBase:            time.Duration(config.LoginDelayBase) * time.Second,
+		DelayMax:             time.Duration(config.LoginDelayMax) * time.Second,
+		LoginLockoutAttempts: config.LoginLockoutAttempts,
+		IPLockoutAttempts:    config.IPLockoutAttempts,
+		LockoutDuration:      time.Duration(config.LoginLockoutDuration) * time.Second,
+	})
+	// A reset request needs no delay, only a limit: every request within the window passes until the limit is reached
+	resetThrottle := service.NewLoginThrottle(transactionManager, loginAttemptRepository, service.LoginThrottleOptions{
+		FreeAttempts:         config.PasswordResetLoginRequests,
+		LoginLockoutAttempts: config.PasswordResetLoginRequests,
+		IPLockoutAttempts:    config.PasswordResetIPRequests,
+		LockoutDuration:      time.Duration(config.PasswordResetWindow) * time.Minute,
+		LoginKind:            model.ResetRequestByLogin,
+		IPKind:               model.ResetRequestByIP,
+	})
+	userService := service.NewUserService(transactionManager, userRepository, balanceRepository, tokenService, loginThrottle, passwords)
+	notifier := newNotifier(config)
+	if notifier == nil {
+		zap.L().Warn("Password reset is disabled, set PASSWORD_RESET_FILE to enable it")
+	}
+	passwordService := service.NewPasswordService(
+		transactionManager,
+		userRepository,
+		tokenRepository,
+		passwordResetRepository,
+		loginThrottle,
+		resetThrottle,
+		passwords,
+		notifier,
+		time.Duration(config.PasswordResetTokenLifetime)*time.Minute,
+	)
+	withdrawalService := service.NewWithdrawalService(transactionManager, withdrawalRepository, orderRepository, balanceRepository, ledgerRepository)
+
+	// Build accrual system integration
+	accrualRateLimiter := integration.NewRateLimiter(config.AccrualRateLimit)
+	accrualCircuitBreaker := integration.NewCircuitBreaker(
+		config.AccrualBreakerFailures,
+		time.Duration(config.AccrualBreakerTimeout)*time.Second,
+		config.AccrualBreakerHalfOpenRequests,
+	)
+	accrualClient := integration.NewAccrualClient(config.AccrualSystemAddress, accrualRateLimiter, accrualCircuitBreaker)
+	accrualBatchClient := integration.NewFanOutBatchClient(accrualClient, config.AccrualLookupConcurrency)
 
 	// Build handlers
-	orderHandler := handler.NewOrderHandler(orderService)
+	pageLimits := handler.PageLimits{Default: config.PageSizeDefault, Max: config.PageSizeMax}
+	orderHandler := handler.NewOrderHandler(orderService, pageLimits)
 	balanceHandler := handler.NewBalanceHandler(balanceService)
-	userHandler := handler.NewUserHandler(userService)
-	withdrawalHandler := handler.NewWithdrawalHandler(withdrawalService)
+	userHandler := handler.NewUserHandler(userService, userValidator)
+	tokenHandler := handler.NewTokenHandler(tokenService)
+	passwordHandler := handler.NewPasswordHandler(passwordService, userValidator)
+	withdrawalHandler := handler.NewWithdrawalHandler(withdrawalService, pageLimits)
+	healthHandler := handler.NewHealthHandler(dbConnection, accrualCircuitBreaker)
+	metricsHandler := handler.NewMetricsHandler(accrualCircuitBreaker)
+	jwksHandler := handler.NewJWKSHandler(jwtService)
 
-	accrualClient := integration.NewAccrualClient(config.AccrualSystemAddress)
-	accrualJob := job.NewAccrualJob(accrualClient, orderService)
+	accrualJob := job.NewAccrualJob(accrualBatchClient, orderService, job.AccrualJobOptions{
+		InstanceID:      config.InstanceID,
+		Workers:         config.AccrualWorkers,
+		ClaimSize:       config.AccrualBatchSize,
+		LookupBatchSize: config.AccrualLookupBatchSize,
+		Lease:           time.Duration(config.AccrualLease) * time.Second,
+		BackoffBase:     time.Duration(config.AccrualBackoffBase) * time.Second,
+		BackoffMax:      time.Duration(config.AccrualBackoffMax) * time.Second,
+		MaxAttempts:     config.AccrualMaxAttempts,
+		MaxAge:          time.Duration(config.AccrualMaxAge) * time.Hour,
+	})
+
+	cleanupJob := job.NewCleanupJob(
+		job.CleanupTask{
+			Name: "accrual events",
+			Run: func(ctx context.Context) (int64, error) {
+				return orderService.PruneAccrualEvents(ctx, time.Duration(config.AccrualEventRetention)*time.Hour)
+			},
+		},
+		job.CleanupTask{Name: "expired tokens", Run: tokenService.PruneExpiredTokens},
+		job.CleanupTask{Name: "login attempts", Run: loginThrottle.PruneExpired},
+		job.CleanupTask{Name: "password reset requests", Run: resetThrottle.PruneExpired},
+	)
+
+	if config.TrustProxyHeaders {
+		router.Use(chimiddleware.RealIP)
+	}
+	router.Use(chimiddleware.Timeout(time.Duration(config.RequestTimeout) * time.Second))
+
+	router.Get("/health", healthHandler.GetHealth())
+	router.Get("/metrics", metricsHandler.GetMetrics())
+	router.Get("/.well-known/jwks.json", jwksHandler.GetJWKS())
+
+	if !stringutils.IsEmpty(config.AccrualWebhookSecret) {
+		signatureVerifier := security.NewSignatureVerifier([]byte(config.AccrualWebhookSecret), accrualWebhookTolerance)
+		accrualCallbackHandler := handler.NewAccrualCallbackHandler(orderService, signatureVerifier)
+		router.Post("/internal/accrual/callback", accrualCallbackHandler.HandleCallback())
+	}
 
 	router.Route("/api/user", func(r chi.Router) {
 		r.Group(func(r chi.Router) {
 			r.Post("/register", userHandler.RegisterUser())
 			r.Post("/login", userHandler.LoginUser())
+			r.Post("/token/refresh", tokenHandler.RefreshToken())
+			if notifier != nil {
+				r.Post("/password/reset", passwordHandler.RequestPasswordReset())
+				r.Post("/password/reset/confirm", passwordHandler.ConfirmPasswordReset())
+			}
 		})
 
 		r.Group(func(r chi.Router) {
-			r.Use(middleware.AuthorizationMiddleware(jwtService))
+			r.Use(middleware.AuthorizationMiddleware(jwtService, tokenService))
+			r.Post("/logout", tokenHandler.Logout())
+			r.Post("/password", passwordHandler.ChangePassword())
 			r.Post("/orders", orderHandler.CreateOrder())
+			r.Post("/orders/batch", orderHandler.CreateOrders())
 			r.Get("/orders", orderHandler.GetOrders())
+			r.Get("/orders/{number}", orderHandler.GetOrder())
 			r.Get("/balance", balanceHandler.GetBalance())
 			r.Post("/balance/withdraw", withdrawalHandler.CreateWithdrawal())
 			r.Get("/withdrawals", withdrawalHandler.GetWithdrawals())
 		})
 	})
 
+	var jobs sync.WaitGroup
+	jobs.Add(1)
 	go func() {
-		ticker := time.NewTicker(1000 * time.Millisecond)
-		for range ticker.C {
-			accrualJob.Start()
-		}
+		defer jobs.Done()
+		accrualJob.Run(ctx, time.Duration(config.AccrualPollInterval)*time.Millisecond)
 	}()
 
-	//TODO: Не забыть обработку сигналов
-	err = http.ListenAndServe(config.RunAddress, router)
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		cleanupJob.Run(ctx, time.Duration(config.CleanupInterval)*time.Minute)
+	}()
+
+	server := &http.Server{Addr: config.RunAddress, Handler: router}
+
+	serverErrors := make(chan error, 1)
+	go func() {
+		zap.L().Info("Starting server", zap.String("address", config.RunAddress))
+		serverErrors <- server.ListenAndServe()
+	}()
+
+	exitCode := exitCodeOK
+
+	select {
+	case err = <-serverErrors:
+		zap.L().Error("Failed to start server", zap.Error(err))
+		exitCode = exitCodeFailure
+		stop()
+	case <-ctx.Done():
+		zap.L().Info("Shutdown signal received")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout)*time.Second)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		zap.L().Error("Failed to gracefully shutdown server", zap.Error(err))
+		exitCode = exitCodeShutdown
+	}
+
+	jobsDone := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(jobsDone)
+	}()
+
+	select {
+	case <-jobsDone:
+	case <-shutdownCtx.Done():
+		zap.L().Error("Background jobs did not stop in time")
+		exitCode = exitCodeShutdown
+	}
+
+	zap.L().Info("Server stopped", zap.Int("exitCode", exitCode))
+	return exitCode
+}
+
+// newJwtService signs with the configured key file if there is one, and with the shared secret otherwise.
+func newJwtService(config *configuration.Configuration, lifetime time.Duration) (*security.JwtService, error) {
+	if stringutils.IsEmpty(config.JwtSigningKeyFile) {
+		return security.NewJwtService([]byte(config.JwtSecret), lifetime), nil
+	}
+
+	signingKey, err := security.LoadKey(config.JwtSigningKeyFile)
 	if err != nil {
-		zap.L().Fatal("Failed to start server", zap.Error(err))
+		return nil, err
+	}
+
+	verificationKeys := make([]*security.Key, 0, len(config.JwtVerificationKeyFiles))
+	for _, path := range config.JwtVerificationKeyFiles {
+		key, err := security.LoadKey(path)
+		if err != nil {
+			return nil, err
+		}
+		verificationKeys = append(verificationKeys, key)
+	}
+
+	return security.NewAsymmetricJwtService(signingKey, verificationKeys, lifetime)
+}
+
+// newNotifier writes notifications to the configured file, or to the log in dev mode. Outside dev mode
+// without a file it returns nil: reset tokens are never written to the log of a production deployment.
+func newNotifier(config *configuration.Configuration) notification.Notifier {
+	if stringutils.IsEmpty(config.PasswordResetFile) {
+		if !config.DevMode {
+			return nil
+		}
+		return notification.NewLogNotifier()
+	}
+
+	return notification.NewFileNotifier(config.PasswordResetFile)
+}
+
+// newPasswords hashes new passwords with the configured algorithm and still accepts hashes of the other one,
+// which are upgraded on the next login.
+func newPasswords(config *configuration.Configuration) (*security.Passwords, error) {
+	bcryptHasher, err := security.NewBcryptHasher(config.BcryptCost)
+	if err != nil {
+		return nil, err
+	}
+
+	argon2Hasher, err := security.NewArgon2idHasher(security.Argon2Params{
+		Memory:      uint32(config.Argon2Memory),
+		Iterations:  uint32(config.Argon2Iterations),
+		Parallelism: uint8(config.Argon2Parallelism),
+		SaltLength:  16,
+		KeyLength:   32,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.PasswordHashAlgorithm {
+	case "argon2id":
+		return security.NewPasswords(argon2Hasher, bcryptHasher), nil
+	case "bcrypt":
+		return security.NewPasswords(bcryptHasher, argon2Hasher), nil
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", config.PasswordHashAlgorithm)
 	}
 }
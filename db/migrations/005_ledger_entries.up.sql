@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS ledger_entries
+(
+    id            SERIAL PRIMARY KEY,
+    user_id       INT REFERENCES users (id)          NOT NULL,
+    type          VARCHAR(50)                        NOT NULL,
+    amount        FLOAT                              NOT NULL,
+    order_number  VARCHAR(255),
+    withdrawal_id INT REFERENCES withdrawals (id),
+    reversal_of   INT REFERENCES ledger_entries (id) UNIQUE,
+    comment       TEXT,
+    created_at    TIMESTAMP WITH TIME ZONE           NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_user_id_idx ON ledger_entries (user_id);
+
+INSERT INTO ledger_entries (user_id, type, amount, order_number, created_at)
+SELECT user_id, 'ACCRUAL', accrual, number, uploaded_at
+FROM orders
+WHERE accrual <> 0;
+
+INSERT INTO ledger_entries (user_id, type, amount, order_number, withdrawal_id, created_at)
+SELECT user_id, 'WITHDRAWAL', -sum, order_number, id, processed_at
+FROM withdrawals;
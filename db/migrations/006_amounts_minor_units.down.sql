@@ -0,0 +1,14 @@
+ALTER TABLE ledger_entries ALTER COLUMN amount TYPE FLOAT USING amount / 100.0;
+
+ALTER TABLE withdrawals ALTER COLUMN sum TYPE FLOAT USING sum / 100.0;
+
+ALTER TABLE balances ALTER COLUMN current DROP DEFAULT;
+ALTER TABLE balances ALTER COLUMN withdrawn DROP DEFAULT;
+ALTER TABLE balances ALTER COLUMN current TYPE FLOAT USING current / 100.0;
+ALTER TABLE balances ALTER COLUMN withdrawn TYPE FLOAT USING withdrawn / 100.0;
+ALTER TABLE balances ALTER COLUMN current SET DEFAULT 0;
+ALTER TABLE balances ALTER COLUMN withdrawn SET DEFAULT 0;
+
+ALTER TABLE orders ALTER COLUMN accrual DROP DEFAULT;
+ALTER TABLE orders ALTER COLUMN accrual TYPE FLOAT USING accrual / 100.0;
+ALTER TABLE orders ALTER COLUMN accrual SET DEFAULT 0;
@@ -0,0 +1,14 @@
+ALTER TABLE orders ALTER COLUMN accrual DROP DEFAULT;
+ALTER TABLE orders ALTER COLUMN accrual TYPE BIGINT USING ROUND(accrual::NUMERIC * 100)::BIGINT;
+ALTER TABLE orders ALTER COLUMN accrual SET DEFAULT 0;
+
+ALTER TABLE balances ALTER COLUMN current DROP DEFAULT;
+ALTER TABLE balances ALTER COLUMN withdrawn DROP DEFAULT;
+ALTER TABLE balances ALTER COLUMN current TYPE BIGINT USING ROUND(current::NUMERIC * 100)::BIGINT;
+ALTER TABLE balances ALTER COLUMN withdrawn TYPE BIGINT USING ROUND(withdrawn::NUMERIC * 100)::BIGINT;
+ALTER TABLE balances ALTER COLUMN current SET DEFAULT 0;
+ALTER TABLE balances ALTER COLUMN withdrawn SET DEFAULT 0;
+
+ALTER TABLE withdrawals ALTER COLUMN sum TYPE BIGINT USING ROUND(sum::NUMERIC * 100)::BIGINT;
+
+ALTER TABLE ledger_entries ALTER COLUMN amount TYPE BIGINT USING ROUND(amount::NUMERIC * 100)::BIGINT;
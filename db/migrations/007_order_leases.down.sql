@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS orders_not_terminated_idx;
+
+ALTER TABLE orders DROP COLUMN IF EXISTS lease_until;
+ALTER TABLE orders DROP COLUMN IF EXISTS claimed_by;
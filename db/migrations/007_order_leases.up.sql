@@ -0,0 +1,4 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS claimed_by VARCHAR(255);
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS lease_until TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS orders_not_terminated_idx ON orders (uploaded_at) WHERE status NOT IN ('INVALID', 'PROCESSED');
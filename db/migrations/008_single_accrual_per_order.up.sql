@@ -0,0 +1 @@
+CREATE UNIQUE INDEX IF NOT EXISTS ledger_entries_accrual_order_number_idx ON ledger_entries (order_number) WHERE type = 'ACCRUAL';
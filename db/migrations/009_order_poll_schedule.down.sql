@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS orders_next_poll_at_idx;
+CREATE INDEX IF NOT EXISTS orders_not_terminated_idx ON orders (uploaded_at) WHERE status NOT IN ('INVALID', 'PROCESSED');
+
+ALTER TABLE orders DROP COLUMN IF EXISTS attempts;
+ALTER TABLE orders DROP COLUMN IF EXISTS next_poll_at;
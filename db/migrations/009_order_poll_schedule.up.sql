@@ -0,0 +1,5 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS next_poll_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0;
+
+DROP INDEX IF EXISTS orders_not_terminated_idx;
+CREATE INDEX IF NOT EXISTS orders_next_poll_at_idx ON orders (next_poll_at) WHERE status NOT IN ('INVALID', 'PROCESSED', 'STALE');
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS accrual_events;
\ No newline at end of file
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS accrual_events
+(
+    id           BIGSERIAL PRIMARY KEY,
+    order_number VARCHAR(255)             NOT NULL,
+    source       VARCHAR(50)              NOT NULL,
+    status       VARCHAR(50)              NOT NULL,
+    accrual      BIGINT                   NOT NULL DEFAULT 0,
+    received_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    applied_at   TIMESTAMP WITH TIME ZONE,
+    outcome      VARCHAR(50),
+    error        TEXT
+);
+
+CREATE INDEX IF NOT EXISTS accrual_events_order_number_idx ON accrual_events (order_number);
+CREATE INDEX IF NOT EXISTS accrual_events_unapplied_idx ON accrual_events (id) WHERE applied_at IS NULL;
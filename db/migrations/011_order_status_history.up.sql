@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS order_status_history
+(
+    id         BIGSERIAL PRIMARY KEY,
+    order_id   INT REFERENCES orders (id) NOT NULL,
+    status     VARCHAR(50)                NOT NULL,
+    accrual    BIGINT                     NOT NULL DEFAULT 0,
+    changed_at TIMESTAMP WITH TIME ZONE   NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS order_status_history_order_id_idx ON order_status_history (order_id);
+
+INSERT INTO order_status_history (order_id, status, changed_at)
+SELECT id, 'NEW', uploaded_at
+FROM orders;
+
+-- When existing orders left NEW is unknown, so their current status is dated by the migration
+INSERT INTO order_status_history (order_id, status, accrual)
+SELECT id, status, accrual
+FROM orders
+WHERE status <> 'NEW';
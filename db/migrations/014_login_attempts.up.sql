@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS login_attempts
+(
+    kind            VARCHAR(16)              NOT NULL,
+    key             VARCHAR(255)             NOT NULL,
+    failures        INT                      NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    blocked_until   TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (kind, key)
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_last_failure_at_idx ON login_attempts (last_failure_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS refresh_tokens_user_id_idx;
+DROP TABLE IF EXISTS password_reset_tokens;
+ALTER TABLE users DROP COLUMN IF EXISTS token_version;
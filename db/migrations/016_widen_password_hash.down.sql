@@ -0,0 +1,2 @@
+-- Fails if argon2id hashes, which do not fit into 72 characters, are stored
+ALTER TABLE users ALTER COLUMN password TYPE VARCHAR(72);
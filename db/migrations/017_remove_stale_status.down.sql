@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS orders_next_poll_at_idx;
+CREATE INDEX IF NOT EXISTS orders_next_poll_at_idx ON orders (next_poll_at) WHERE status NOT IN ('INVALID', 'PROCESSED', 'STALE');
@@ -0,0 +1,17 @@
+-- STALE is not a status of the specification: such orders go back to their last real status and are polled again
+UPDATE orders o
+SET status       = COALESCE((SELECT h.status
+                             FROM order_status_history h
+                             WHERE h.order_id = o.id AND h.status <> 'STALE'
+                             ORDER BY h.changed_at DESC, h.id DESC
+                             LIMIT 1), 'NEW'),
+    attempts     = 0,
+    next_poll_at = now(),
+    claimed_by   = NULL,
+    lease_until  = NULL
+WHERE o.status = 'STALE';
+
+DELETE FROM order_status_history WHERE status = 'STALE';
+
+DROP INDEX IF EXISTS orders_next_poll_at_idx;
+CREATE INDEX IF NOT EXISTS orders_next_poll_at_idx ON orders (next_poll_at) WHERE status NOT IN ('INVALID', 'PROCESSED');
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS accrual_events_noise_idx ON accrual_events (received_at) WHERE outcome IN ('IGNORED', 'UNCHANGED');
@@ -0,0 +1,2 @@
+-- The hashes can not be turned back into logins, the counters start over
+DELETE FROM login_attempts;
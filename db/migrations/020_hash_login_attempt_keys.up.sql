@@ -0,0 +1,2 @@
+-- Keys are stored as the SHA-256 of the login or address, so logins of any length fit
+UPDATE login_attempts SET key = encode(sha256(convert_to(key, 'UTF8')), 'hex');
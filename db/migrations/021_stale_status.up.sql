@@ -0,0 +1,3 @@
+-- STALE is back as an internal terminal status of orders given up on, stale orders are not polled
+DROP INDEX IF EXISTS orders_next_poll_at_idx;
+CREATE INDEX IF NOT EXISTS orders_next_poll_at_idx ON orders (next_poll_at) WHERE status NOT IN ('INVALID', 'PROCESSED', 'STALE');
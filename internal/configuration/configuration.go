@@ -1,27 +1,135 @@
 package configuration
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"github.com/caarlos0/env/v11"
 	"github.com/zavtra-na-rabotu/gophermart/internal/utils/stringutils"
 	"go.uber.org/zap"
 	"os"
+	"strings"
 )
 
+// defaultJwtSecret is only accepted in dev mode, see Validate.
+const defaultJwtSecret = "secret"
+
+var ErrDefaultJwtSecret = errors.New("default JWT secret is not allowed outside dev mode: set JWT_SECRET or JWT_SIGNING_KEY_FILE")
+
 type Configuration struct {
-	RunAddress           string
-	DatabaseURI          string
-	AccrualSystemAddress string
-	JwtSecret            string
-	JwtLifetimeHours     int
+	RunAddress                     string
+	DatabaseURI                    string
+	AccrualSystemAddress           string
+	JwtSecret                      string
+	JwtLifetimeHours               int
+	AccessTokenLifetime            int
+	RefreshTokenLifetime           int
+	ShutdownTimeout                int
+	AccrualPollInterval            int
+	RequestTimeout                 int
+	AccrualWorkers                 int
+	AccrualRateLimit               int
+	AccrualBatchSize               int
+	AccrualLease                   int
+	InstanceID                     string
+	AccrualBackoffBase             int
+	AccrualBackoffMax              int
+	AccrualMaxAttempts             int
+	AccrualMaxAge                  int
+	AccrualBreakerFailures         int
+	AccrualBreakerTimeout          int
+	AccrualBreakerHalfOpenRequests int
+	AccrualWebhookSecret           string
+	AccrualLookupBatchSize         int
+	AccrualLookupConcurrency       int
+	PageSizeDefault                int
+	PageSizeMax                    int
+	JwtSigningKeyFile              string
+	JwtVerificationKeyFiles        []string
+	DevMode                        bool
+	LoginFreeAttempts              int
+	LoginDelayBase                 int
+	LoginDelayMax                  int
+	LoginLockoutAttempts           int
+	IPLockoutAttempts              int
+	LoginLockoutDuration           int
+	TrustProxyHeaders              bool
+	LoginMinLength                 int
+	LoginMaxLength                 int
+	LoginPattern                   string
+	PasswordMinLength              int
+	PasswordMinClasses             int
+	BreachedPasswordsFile          string
+	PasswordResetTokenLifetime     int
+	PasswordResetLoginRequests     int
+	PasswordResetIPRequests        int
+	PasswordResetWindow            int
+	PasswordResetFile              string
+	PasswordHashAlgorithm          string
+	BcryptCost                     int
+	Argon2Memory                   int
+	Argon2Iterations               int
+	Argon2Parallelism              int
+	CleanupInterval                int
+	AccrualEventRetention          int
 }
 
 type envs struct {
-	RunAddress           string `env:"RUN_ADDRESS"`
-	DatabaseURI          string `env:"DATABASE_URI"`
-	AccrualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
-	JwtSecret            string `env:"JWT_SECRET"`
-	JwtLifetimeHours     int    `env:"JWT_LIFETIME_HOURS"`
+	RunAddress                     string `env:"RUN_ADDRESS"`
+	DatabaseURI                    string `env:"DATABASE_URI"`
+	AccrualSystemAddress           string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	JwtSecret                      string `env:"JWT_SECRET"`
+	JwtLifetimeHours               int    `env:"JWT_LIFETIME_HOURS"`
+	AccessTokenLifetime            int    `env:"ACCESS_TOKEN_LIFETIME"`
+	RefreshTokenLifetime           int    `env:"REFRESH_TOKEN_LIFETIME"`
+	ShutdownTimeout                int    `env:"SHUTDOWN_TIMEOUT"`
+	AccrualPollInterval            int    `env:"ACCRUAL_POLL_INTERVAL"`
+	RequestTimeout                 int    `env:"REQUEST_TIMEOUT"`
+	AccrualWorkers                 int    `env:"ACCRUAL_WORKERS"`
+	AccrualRateLimit               int    `env:"ACCRUAL_RATE_LIMIT"`
+	AccrualBatchSize               int    `env:"ACCRUAL_BATCH_SIZE"`
+	AccrualLease                   int    `env:"ACCRUAL_LEASE"`
+	InstanceID                     string `env:"INSTANCE_ID"`
+	AccrualBackoffBase             int    `env:"ACCRUAL_BACKOFF_BASE"`
+	AccrualBackoffMax              int    `env:"ACCRUAL_BACKOFF_MAX"`
+	AccrualMaxAttempts             int    `env:"ACCRUAL_MAX_ATTEMPTS"`
+	AccrualMaxAge                  int    `env:"ACCRUAL_MAX_AGE"`
+	AccrualBreakerFailures         int    `env:"ACCRUAL_BREAKER_FAILURES"`
+	AccrualBreakerTimeout          int    `env:"ACCRUAL_BREAKER_TIMEOUT"`
+	AccrualBreakerHalfOpenRequests int    `env:"ACCRUAL_BREAKER_HALF_OPEN_REQUESTS"`
+	AccrualWebhookSecret           string `env:"ACCRUAL_WEBHOOK_SECRET"`
+	AccrualLookupBatchSize         int    `env:"ACCRUAL_LOOKUP_BATCH_SIZE"`
+	AccrualLookupConcurrency       int    `env:"ACCRUAL_LOOKUP_CONCURRENCY"`
+	PageSizeDefault                int    `env:"PAGE_SIZE_DEFAULT"`
+	PageSizeMax                    int    `env:"PAGE_SIZE_MAX"`
+	JwtSigningKeyFile              string `env:"JWT_SIGNING_KEY_FILE"`
+	JwtVerificationKeyFiles        string `env:"JWT_VERIFICATION_KEY_FILES"`
+	DevMode                        bool   `env:"DEV_MODE"`
+	LoginFreeAttempts              int    `env:"LOGIN_FREE_ATTEMPTS"`
+	LoginDelayBase                 int    `env:"LOGIN_DELAY_BASE"`
+	LoginDelayMax                  int    `env:"LOGIN_DELAY_MAX"`
+	LoginLockoutAttempts           int    `env:"LOGIN_LOCKOUT_ATTEMPTS"`
+	IPLockoutAttempts              int    `env:"IP_LOCKOUT_ATTEMPTS"`
+	LoginLockoutDuration           int    `env:"LOGIN_LOCKOUT_DURATION"`
+	TrustProxyHeaders              bool   `env:"TRUST_PROXY_HEADERS"`
+	LoginMinLength                 int    `env:"LOGIN_MIN_LENGTH"`
+	LoginMaxLength                 int    `env:"LOGIN_MAX_LENGTH"`
+	LoginPattern                   string `env:"LOGIN_PATTERN"`
+	PasswordMinLength              int    `env:"PASSWORD_MIN_LENGTH"`
+	PasswordMinClasses             int    `env:"PASSWORD_MIN_CLASSES"`
+	BreachedPasswordsFile          string `env:"BREACHED_PASSWORDS_FILE"`
+	PasswordResetTokenLifetime     int    `env:"PASSWORD_RESET_TOKEN_LIFETIME"`
+	PasswordResetLoginRequests     int    `env:"PASSWORD_RESET_LOGIN_REQUESTS"`
+	PasswordResetIPRequests        int    `env:"PASSWORD_RESET_IP_REQUESTS"`
+	PasswordResetWindow            int    `env:"PASSWORD_RESET_WINDOW"`
+	PasswordResetFile              string `env:"PASSWORD_RESET_FILE"`
+	PasswordHashAlgorithm          string `env:"PASSWORD_HASH_ALGORITHM"`
+	BcryptCost                     int    `env:"BCRYPT_COST"`
+	Argon2Memory                   int    `env:"ARGON2_MEMORY"`
+	Argon2Iterations               int    `env:"ARGON2_ITERATIONS"`
+	Argon2Parallelism              int    `env:"ARGON2_PARALLELISM"`
+	CleanupInterval                int    `env:"CLEANUP_INTERVAL"`
+	AccrualEventRetention          int    `env:"ACCRUAL_EVENT_RETENTION"`
 }
 
 func Configure() *Configuration {
@@ -30,8 +138,61 @@ func Configure() *Configuration {
 	flag.StringVar(&config.RunAddress, "a", "localhost:8080", "Адрес и порт запуска сервиса")
 	flag.StringVar(&config.DatabaseURI, "d", "", "Адрес подключения к базе данных")
 	flag.StringVar(&config.AccrualSystemAddress, "r", "", "Адрес системы расчёта начислений")
-	flag.StringVar(&config.JwtSecret, "j", "secret", "JWT секрет")
-	flag.IntVar(&config.JwtLifetimeHours, "l", 24, "Время жизни JWT токена в часах")
+	flag.StringVar(&config.JwtSecret, "j", defaultJwtSecret, "JWT секрет")
+	flag.IntVar(&config.JwtLifetimeHours, "l", 0, "Время жизни JWT токена в часах (если задано, заменяет access-token-lifetime)")
+	flag.IntVar(&config.AccessTokenLifetime, "access-token-lifetime", 15, "Время жизни access токена в минутах")
+	flag.IntVar(&config.RefreshTokenLifetime, "refresh-token-lifetime", 720, "Время жизни refresh токена в часах")
+	flag.IntVar(&config.ShutdownTimeout, "shutdown-timeout", 30, "Время на корректное завершение работы в секундах")
+	flag.IntVar(&config.AccrualPollInterval, "accrual-poll-interval", 1000, "Интервал опроса системы расчёта начислений в миллисекундах")
+	flag.IntVar(&config.RequestTimeout, "request-timeout", 10, "Максимальное время обработки запроса в секундах")
+	flag.IntVar(&config.AccrualWorkers, "accrual-workers", 4, "Количество воркеров опроса системы расчёта начислений")
+	flag.IntVar(&config.AccrualRateLimit, "accrual-rate-limit", 0, "Ограничение запросов к системе расчёта начислений в секунду (0 - без ограничения)")
+	flag.IntVar(&config.AccrualBatchSize, "accrual-batch-size", 100, "Количество заказов, забираемых на обработку за один проход")
+	flag.IntVar(&config.AccrualLease, "accrual-lease", 60, "Время аренды заказа экземпляром сервиса в секундах")
+	flag.StringVar(&config.InstanceID, "instance-id", "", "Идентификатор экземпляра сервиса (по умолчанию hostname-pid)")
+	flag.IntVar(&config.AccrualBackoffBase, "accrual-backoff-base", 1, "Начальная задержка повторного опроса заказа в секундах")
+	flag.IntVar(&config.AccrualBackoffMax, "accrual-backoff-max", 600, "Максимальная задержка повторного опроса заказа в секундах")
+	flag.IntVar(&config.AccrualMaxAttempts, "accrual-max-attempts", 50, "Количество опросов заказа без изменений, после которого он помечается STALE и больше не опрашивается (0 - без ограничения)")
+	flag.IntVar(&config.AccrualMaxAge, "accrual-max-age", 72, "Возраст заказа в часах, после которого он помечается STALE и больше не опрашивается (0 - без ограничения)")
+	flag.IntVar(&config.AccrualBreakerFailures, "accrual-breaker-failures", 5, "Количество ошибок подряд, после которого запросы к системе расчёта начислений прекращаются")
+	flag.IntVar(&config.AccrualBreakerTimeout, "accrual-breaker-timeout", 30, "Время в секундах до пробного запроса после размыкания")
+	flag.IntVar(&config.AccrualBreakerHalfOpenRequests, "accrual-breaker-half-open-requests", 1, "Количество успешных пробных запросов для замыкания")
+	flag.StringVar(&config.AccrualWebhookSecret, "accrual-webhook-secret", "", "Секрет подписи уведомлений системы расчёта начислений (пустой - приём отключён)")
+	flag.IntVar(&config.AccrualLookupBatchSize, "accrual-lookup-batch-size", 10, "Количество заказов в одном пакетном запросе к системе расчёта начислений")
+	flag.IntVar(&config.AccrualLookupConcurrency, "accrual-lookup-concurrency", 4, "Количество параллельных запросов при разбиении пакета на одиночные запросы")
+	flag.IntVar(&config.PageSizeDefault, "page-size-default", 100, "Количество записей на странице списка, если указан cursor без limit (без обоих возвращается весь список)")
+	flag.IntVar(&config.PageSizeMax, "page-size-max", 1000, "Максимальное количество записей на странице списка")
+	flag.StringVar(&config.JwtSigningKeyFile, "jwt-signing-key", "", "PEM файл закрытого ключа подписи JWT (RSA, P-256 или Ed25519; пустой - подпись секретом)")
+	flag.Func("jwt-verification-keys", "PEM файлы ключей проверки JWT через запятую (предыдущие ключи при ротации)", func(value string) error {
+		config.JwtVerificationKeyFiles = splitList(value)
+		return nil
+	})
+	flag.BoolVar(&config.DevMode, "dev", false, "Режим разработки (разрешает секрет JWT по умолчанию и вывод токенов сброса пароля в лог)")
+	flag.IntVar(&config.LoginFreeAttempts, "login-free-attempts", 3, "Количество неудачных входов подряд без задержки")
+	flag.IntVar(&config.LoginDelayBase, "login-delay-base", 1, "Начальная задержка после неудачного входа в секундах")
+	flag.IntVar(&config.LoginDelayMax, "login-delay-max", 60, "Максимальная задержка после неудачного входа в секундах")
+	flag.IntVar(&config.LoginLockoutAttempts, "login-lockout-attempts", 10, "Количество неудачных входов в логин, после которого он блокируется")
+	flag.IntVar(&config.IPLockoutAttempts, "ip-lockout-attempts", 100, "Количество неудачных входов с одного адреса, после которого он блокируется")
+	flag.IntVar(&config.LoginLockoutDuration, "login-lockout-duration", 900, "Время блокировки входа в секундах")
+	flag.BoolVar(&config.TrustProxyHeaders, "trust-proxy-headers", false, "Брать адрес клиента из заголовков X-Forwarded-For и X-Real-IP (только за доверенным прокси)")
+	flag.IntVar(&config.LoginMinLength, "login-min-length", 3, "Минимальная длина логина")
+	flag.IntVar(&config.LoginMaxLength, "login-max-length", 64, "Максимальная длина логина")
+	flag.StringVar(&config.LoginPattern, "login-pattern", `^[\p{L}\p{N}._@-]+$`, "Регулярное выражение допустимых символов логина")
+	flag.IntVar(&config.PasswordMinLength, "password-min-length", 8, "Минимальная длина пароля")
+	flag.IntVar(&config.PasswordMinClasses, "password-min-classes", 2, "Минимальное количество классов символов в пароле (строчные, заглавные, цифры, прочие)")
+	flag.StringVar(&config.BreachedPasswordsFile, "breached-passwords-file", "", "Файл скомпрометированных паролей (пароль или SHA-1 в строке, пустой - без проверки)")
+	flag.IntVar(&config.PasswordResetTokenLifetime, "password-reset-token-lifetime", 30, "Время жизни токена сброса пароля в минутах")
+	flag.IntVar(&config.PasswordResetLoginRequests, "password-reset-login-requests", 3, "Количество запросов сброса пароля для одного логина за окно")
+	flag.IntVar(&config.PasswordResetIPRequests, "password-reset-ip-requests", 20, "Количество запросов сброса пароля с одного адреса за окно")
+	flag.IntVar(&config.PasswordResetWindow, "password-reset-window", 60, "Окно ограничения запросов сброса пароля в минутах")
+	flag.StringVar(&config.PasswordResetFile, "password-reset-file", "", "Файл для уведомлений о сбросе пароля (пустой - вывод в лог в режиме разработки, иначе сброс пароля отключён)")
+	flag.StringVar(&config.PasswordHashAlgorithm, "password-hash-algorithm", "argon2id", "Алгоритм хеширования новых паролей (argon2id или bcrypt)")
+	flag.IntVar(&config.BcryptCost, "bcrypt-cost", 10, "Стоимость bcrypt")
+	flag.IntVar(&config.Argon2Memory, "argon2-memory", 19456, "Память argon2id в КиБ")
+	flag.IntVar(&config.Argon2Iterations, "argon2-iterations", 2, "Количество итераций argon2id")
+	flag.IntVar(&config.Argon2Parallelism, "argon2-parallelism", 1, "Количество потоков argon2id")
+	flag.IntVar(&config.CleanupInterval, "cleanup-interval", 60, "Интервал удаления устаревших данных в минутах")
+	flag.IntVar(&config.AccrualEventRetention, "accrual-event-retention", 168, "Время хранения ответов системы начислений без изменения статуса заказа в часах")
 	flag.Parse()
 
 	envVariables := envs{}
@@ -65,5 +226,289 @@ func Configure() *Configuration {
 		config.JwtLifetimeHours = envVariables.JwtLifetimeHours
 	}
 
+	_, exists = os.LookupEnv("ACCESS_TOKEN_LIFETIME")
+	if exists {
+		config.AccessTokenLifetime = envVariables.AccessTokenLifetime
+	}
+
+	_, exists = os.LookupEnv("REFRESH_TOKEN_LIFETIME")
+	if exists {
+		config.RefreshTokenLifetime = envVariables.RefreshTokenLifetime
+	}
+
+	_, exists = os.LookupEnv("SHUTDOWN_TIMEOUT")
+	if exists {
+		config.ShutdownTimeout = envVariables.ShutdownTimeout
+	}
+
+	_, exists = os.LookupEnv("ACCRUAL_POLL_INTERVAL")
+	if exists {
+		config.AccrualPollInterval = envVariables.AccrualPollInterval
+	}
+
+	_, exists = os.LookupEnv("REQUEST_TIMEOUT")
+	if exists {
+		config.RequestTimeout = envVariables.RequestTimeout
+	}
+
+	_, exists = os.LookupEnv("ACCRUAL_WORKERS")
+	if exists {
+		config.AccrualWorkers = envVariables.AccrualWorkers
+	}
+
+	_, exists = os.LookupEnv("ACCRUAL_RATE_LIMIT")
+	if exists {
+		config.AccrualRateLimit = envVariables.AccrualRateLimit
+	}
+
+	_, exists = os.LookupEnv("ACCRUAL_BATCH_SIZE")
+	if exists {
+		config.AccrualBatchSize = envVariables.AccrualBatchSize
+	}
+
+	_, exists = os.LookupEnv("ACCRUAL_LEASE")
+	if exists {
+		config.AccrualLease = envVariables.AccrualLease
+	}
+
+	_, exists = os.LookupEnv("INSTANCE_ID")
+	if exists && !stringutils.IsEmpty(envVariables.InstanceID) {
+		config.InstanceID = envVariables.InstanceID
+	}
+
+	_, exists = os.LookupEnv("ACCRUAL_BACKOFF_BASE")
+	if exists {
+		config.AccrualBackoffBase = envVariables.AccrualBackoffBase
+	}
+
+	_, exists = os.LookupEnv("ACCRUAL_BACKOFF_MAX")
+	if exists {
+		config.AccrualBackoffMax = envVariables.AccrualBackoffMax
+	}
+
+	_, exists = os.LookupEnv("ACCRUAL_MAX_ATTEMPTS")
+	if exists {
+		config.AccrualMaxAttempts = envVariables.AccrualMaxAttempts
+	}
+
+	_, exists = os.LookupEnv("ACCRUAL_MAX_AGE")
+	if exists {
+		config.AccrualMaxAge = envVariables.AccrualMaxAge
+	}
+
+	_, exists = os.LookupEnv("ACCRUAL_BREAKER_FAILURES")
+	if exists {
+		config.AccrualBreakerFailures = envVariables.AccrualBreakerFailures
+	}
+
+	_, exists = os.LookupEnv("ACCRUAL_BREAKER_TIMEOUT")
+	if exists {
+		config.AccrualBreakerTimeout = envVariables.AccrualBreakerTimeout
+	}
+
+	_, exists = os.LookupEnv("ACCRUAL_BREAKER_HALF_OPEN_REQUESTS")
+	if exists {
+		config.AccrualBreakerHalfOpenRequests = envVariables.AccrualBreakerHalfOpenRequests
+	}
+
+	_, exists = os.LookupEnv("ACCRUAL_WEBHOOK_SECRET")
+	if exists {
+		config.AccrualWebhookSecret = envVariables.AccrualWebhookSecret
+	}
+
+	_, exists = os.LookupEnv("ACCRUAL_LOOKUP_BATCH_SIZE")
+	if exists {
+		config.AccrualLookupBatchSize = envVariables.AccrualLookupBatchSize
+	}
+
+	_, exists = os.LookupEnv("ACCRUAL_LOOKUP_CONCURRENCY")
+	if exists {
+		config.AccrualLookupConcurrency = envVariables.AccrualLookupConcurrency
+	}
+
+	_, exists = os.LookupEnv("PAGE_SIZE_DEFAULT")
+	if exists {
+		config.PageSizeDefault = envVariables.PageSizeDefault
+	}
+
+	_, exists = os.LookupEnv("PAGE_SIZE_MAX")
+	if exists {
+		config.PageSizeMax = envVariables.PageSizeMax
+	}
+
+	_, exists = os.LookupEnv("JWT_SIGNING_KEY_FILE")
+	if exists {
+		config.JwtSigningKeyFile = envVariables.JwtSigningKeyFile
+	}
+
+	_, exists = os.LookupEnv("JWT_VERIFICATION_KEY_FILES")
+	if exists {
+		config.JwtVerificationKeyFiles = splitList(envVariables.JwtVerificationKeyFiles)
+	}
+
+	_, exists = os.LookupEnv("DEV_MODE")
+	if exists {
+		config.DevMode = envVariables.DevMode
+	}
+
+	_, exists = os.LookupEnv("LOGIN_FREE_ATTEMPTS")
+	if exists {
+		config.LoginFreeAttempts = envVariables.LoginFreeAttempts
+	}
+
+	_, exists = os.LookupEnv("LOGIN_DELAY_BASE")
+	if exists {
+		config.LoginDelayBase = envVariables.LoginDelayBase
+	}
+
+	_, exists = os.LookupEnv("LOGIN_DELAY_MAX")
+	if exists {
+		config.LoginDelayMax = envVariables.LoginDelayMax
+	}
+
+	_, exists = os.LookupEnv("LOGIN_LOCKOUT_ATTEMPTS")
+	if exists {
+		config.LoginLockoutAttempts = envVariables.LoginLockoutAttempts
+	}
+
+	_, exists = os.LookupEnv("IP_LOCKOUT_ATTEMPTS")
+	if exists {
+		config.IPLockoutAttempts = envVariables.IPLockoutAttempts
+	}
+
+	_, exists = os.LookupEnv("LOGIN_LOCKOUT_DURATION")
+	if exists {
+		config.LoginLockoutDuration = envVariables.LoginLockoutDuration
+	}
+
+	_, exists = os.LookupEnv("TRUST_PROXY_HEADERS")
+	if exists {
+		config.TrustProxyHeaders = envVariables.TrustProxyHeaders
+	}
+
+	_, exists = os.LookupEnv("LOGIN_MIN_LENGTH")
+	if exists {
+		config.LoginMinLength = envVariables.LoginMinLength
+	}
+
+	_, exists = os.LookupEnv("LOGIN_MAX_LENGTH")
+	if exists {
+		config.LoginMaxLength = envVariables.LoginMaxLength
+	}
+
+	_, exists = os.LookupEnv("LOGIN_PATTERN")
+	if exists {
+		config.LoginPattern = envVariables.LoginPattern
+	}
+
+	_, exists = os.LookupEnv("PASSWORD_MIN_LENGTH")
+	if exists {
+		config.PasswordMinLength = envVariables.PasswordMinLength
+	}
+
+	_, exists = os.LookupEnv("PASSWORD_MIN_CLASSES")
+	if exists {
+		config.PasswordMinClasses = envVariables.PasswordMinClasses
+	}
+
+	_, exists = os.LookupEnv("BREACHED_PASSWORDS_FILE")
+	if exists {
+		config.BreachedPasswordsFile = envVariables.BreachedPasswordsFile
+	}
+
+	_, exists = os.LookupEnv("PASSWORD_RESET_TOKEN_LIFETIME")
+	if exists {
+		config.PasswordResetTokenLifetime = envVariables.PasswordResetTokenLifetime
+	}
+
+	_, exists = os.LookupEnv("PASSWORD_RESET_LOGIN_REQUESTS")
+	if exists {
+		config.PasswordResetLoginRequests = envVariables.PasswordResetLoginRequests
+	}
+
+	_, exists = os.LookupEnv("PASSWORD_RESET_IP_REQUESTS")
+	if exists {
+		config.PasswordResetIPRequests = envVariables.PasswordResetIPRequests
+	}
+
+	_, exists = os.LookupEnv("PASSWORD_RESET_WINDOW")
+	if exists {
+		config.PasswordResetWindow = envVariables.PasswordResetWindow
+	}
+
+	_, exists = os.LookupEnv("PASSWORD_RESET_FILE")
+	if exists {
+		config.PasswordResetFile = envVariables.PasswordResetFile
+	}
+
+	_, exists = os.LookupEnv("PASSWORD_HASH_ALGORITHM")
+	if exists {
+		config.PasswordHashAlgorithm = envVariables.PasswordHashAlgorithm
+	}
+
+	_, exists = os.LookupEnv("BCRYPT_COST")
+	if exists {
+		config.BcryptCost = envVariables.BcryptCost
+	}
+
+	_, exists = os.LookupEnv("ARGON2_MEMORY")
+	if exists {
+		config.Argon2Memory = envVariables.Argon2Memory
+	}
+
+	_, exists = os.LookupEnv("ARGON2_ITERATIONS")
+	if exists {
+		config.Argon2Iterations = envVariables.Argon2Iterations
+	}
+
+	_, exists = os.LookupEnv("ARGON2_PARALLELISM")
+	if exists {
+		config.Argon2Parallelism = envVariables.Argon2Parallelism
+	}
+
+	_, exists = os.LookupEnv("CLEANUP_INTERVAL")
+	if exists {
+		config.CleanupInterval = envVariables.CleanupInterval
+	}
+
+	_, exists = os.LookupEnv("ACCRUAL_EVENT_RETENTION")
+	if exists {
+		config.AccrualEventRetention = envVariables.AccrualEventRetention
+	}
+
+	if stringutils.IsEmpty(config.InstanceID) {
+		config.InstanceID = defaultInstanceID()
+	}
+
 	return &config
 }
+
+// Validate rejects configurations that are only acceptable for local development.
+func (c *Configuration) Validate() error {
+	if !c.DevMode && stringutils.IsEmpty(c.JwtSigningKeyFile) && c.JwtSecret == defaultJwtSecret {
+		return ErrDefaultJwtSecret
+	}
+
+	return nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if !stringutils.IsEmpty(item) {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func defaultInstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "gophermart"
+	}
+
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"time"
+)
+
+const accrualEventColumns = `id, order_number, source, status, accrual, received_at, applied_at, outcome, error`
+
+type AccrualEventRepository struct {
+	db *sql.DB
+}
+
+func NewAccrualEventRepository(db *sql.DB) *AccrualEventRepository {
+	return &AccrualEventRepository{db: db}
+}
+
+// CreateEvent records an event. Events with an outcome are stored as already applied.
+func (r *AccrualEventRepository) CreateEvent(ctx context.Context, tx *sql.Tx, event *model.AccrualEvent) (*model.AccrualEvent, error) {
+	row := tx.QueryRowContext(ctx,
+		`INSERT INTO accrual_events (order_number, source, status, accrual, applied_at, outcome)
+		VALUES ($1, $2, $3, $4, CASE WHEN $5::VARCHAR IS NULL THEN NULL ELSE now() END, $5)
+		RETURNING `+accrualEventColumns,
+		event.OrderNumber, event.Source, event.Status, event.Accrual, event.Outcome,
+	)
+
+	return scanAccrualEvent(row)
+}
+
+// LockUnappliedByID locks the given events that are not applied yet. Events locked by another transaction
+// are skipped: whoever holds the lock applies them.
+func (r *AccrualEventRepository) LockUnappliedByID(ctx context.Context, tx *sql.Tx, ids []int64) ([]model.AccrualEvent, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT `+accrualEventColumns+` FROM accrual_events
+		WHERE id = ANY($1) AND applied_at IS NULL
+		ORDER BY id
+		FOR UPDATE SKIP LOCKED`,
+		ids,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanAccrualEvents(rows)
+}
+
+// LockUnapplied locks up to limit of the oldest events that were received more than olderThan ago and never applied.
+func (r *AccrualEventRepository) LockUnapplied(ctx context.Context, tx *sql.Tx, olderThan time.Duration, limit int) ([]model.AccrualEvent, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT `+accrualEventColumns+` FROM accrual_events
+		WHERE applied_at IS NULL AND received_at < now() - make_interval(secs => $1::FLOAT8)
+		ORDER BY id
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED`,
+		olderThan.Seconds(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return scanAccrualEvents(rows)
+}
+
+// MarkApplied stores the outcome of applying each event. outcomes and errs are parallel to ids.
+func (r *AccrualEventRepository) MarkApplied(ctx context.Context, tx *sql.Tx, ids []int64, outcomes []string, errs []*string) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE accrual_events e
+		SET applied_at = now(), outcome = u.outcome, error = u.error
+		FROM unnest($1::BIGINT[], $2::TEXT[], $3::TEXT[]) AS u(id, outcome, error)
+		WHERE e.id = u.id`,
+		ids, outcomes, errs,
+	)
+	return err
+}
+
+// DeleteNoise removes events received more than olderThan ago that did not change their order, like polls of
+// orders still REGISTERED or PROCESSING. Applied and rejected events are kept as the audit trail of the orders.
+func (r *AccrualEventRepository) DeleteNoise(ctx context.Context, olderThan time.Duration) (int64, error) {
+	result, err := r.db.ExecContext(ctx,
+		`DELETE FROM accrual_events
+		WHERE outcome IN ($1, $2) AND received_at < now() - make_interval(secs => $3::FLOAT8)`,
+		model.EventIgnored, model.EventUnchanged, olderThan.Seconds(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func scanAccrualEvent(row *sql.Row) (*model.AccrualEvent, error) {
+	var event model.AccrualEvent
+
+	err := row.Scan(
+		&event.ID, &event.OrderNumber, &event.Source, &event.Status, &event.Accrual,
+		&event.ReceivedAt, &event.AppliedAt, &event.Outcome, &event.Error,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+func scanAccrualEvents(rows *sql.Rows) ([]model.AccrualEvent, error) {
+	defer rows.Close()
+
+	var events []model.AccrualEvent
+	for rows.Next() {
+		var event model.AccrualEvent
+
+		err := rows.Scan(
+			&event.ID, &event.OrderNumber, &event.Source, &event.Status, &event.Accrual,
+			&event.ReceivedAt, &event.AppliedAt, &event.Outcome, &event.Error,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
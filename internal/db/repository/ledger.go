@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+)
+
+type LedgerRepository struct {
+	db *sql.DB
+}
+
+func NewLedgerRepository(db *sql.DB) *LedgerRepository {
+	return &LedgerRepository{db: db}
+}
+
+func (r *LedgerRepository) CreateEntry(ctx context.Context, tx *sql.Tx, entry *model.LedgerEntry) (*model.LedgerEntry, error) {
+	row := tx.QueryRowContext(ctx,
+		`INSERT INTO ledger_entries (user_id, type, amount, order_number, withdrawal_id, reversal_of, comment)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, user_id, type, amount, order_number, withdrawal_id, reversal_of, comment, created_at`,
+		entry.UserID, entry.Type, entry.Amount, entry.OrderNumber, entry.WithdrawalID, entry.ReversalOf, entry.Comment,
+	)
+
+	var created model.LedgerEntry
+	err := row.Scan(
+		&created.ID, &created.UserID, &created.Type, &created.Amount, &created.OrderNumber,
+		&created.WithdrawalID, &created.ReversalOf, &created.Comment, &created.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
+
+// ledgerBalanceQuery derives current and withdrawn amounts from the ledger.
+// Reversals of withdrawals decrease the withdrawn total.
+const ledgerBalanceQuery = `SELECT
+		COALESCE(SUM(e.amount), 0)::BIGINT,
+		COALESCE(-SUM(e.amount) FILTER (WHERE e.type = $2 OR (e.type = $3 AND o.type = $2)), 0)::BIGINT
+	FROM ledger_entries e
+	LEFT JOIN ledger_entries o ON o.id = e.reversal_of
+	WHERE e.user_id = $1`
+
+// GetBalanceByUserID derives the balance from the ledger within a transaction, e.g. under the lock of the balances row.
+func (r *LedgerRepository) GetBalanceByUserID(ctx context.Context, tx *sql.Tx, userID int) (*model.Balance, error) {
+	return scanLedgerBalance(tx.QueryRowContext(ctx, ledgerBalanceQuery, userID, model.WithdrawalEntry, model.ReversalEntry), userID)
+}
+
+// ReadBalanceByUserID derives the balance from the ledger without a transaction or locks, for display.
+func (r *LedgerRepository) ReadBalanceByUserID(ctx context.Context, userID int) (*model.Balance, error) {
+	return scanLedgerBalance(r.db.QueryRowContext(ctx, ledgerBalanceQuery, userID, model.WithdrawalEntry, model.ReversalEntry), userID)
+}
+
+func scanLedgerBalance(row *sql.Row, userID int) (*model.Balance, error) {
+	balance := model.Balance{UserID: userID}
+	err := row.Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &balance, nil
+}
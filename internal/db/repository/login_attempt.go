@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"time"
+)
+
+type LoginAttemptRepository struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptRepository(db *sql.DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{db: db}
+}
+
+// RecordFailure counts a failed login for the key and locks its counter until the end of the transaction.
+// Failures older than window are forgotten, so the count starts over. Returns the number of failures
+// within the window including this one and the time the key was blocked until before, if any.
+func (r *LoginAttemptRepository) RecordFailure(
+	ctx context.Context,
+	tx *sql.Tx,
+	key model.LoginAttemptKey,
+	window time.Duration,
+) (int, *time.Time, error) {
+	row := tx.QueryRowContext(ctx,
+		`INSERT INTO login_attempts (kind, key, failures, last_failure_at) VALUES ($1, $2, 1, now())
+		ON CONFLICT (kind, key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < now() - $3 * interval '1 second'
+				THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failure_at = now()
+		RETURNING failures, blocked_until`,
+		key.Kind, hashLoginAttemptKey(key), window.Seconds(),
+	)
+
+	var failures int
+	var blockedUntil *time.Time
+	err := row.Scan(&failures, &blockedUntil)
+	return failures, blockedUntil, err
+}
+
+// Block blocks the key until the given time, unless it is already blocked for longer.
+func (r *LoginAttemptRepository) Block(ctx context.Context, tx *sql.Tx, key model.LoginAttemptKey, until time.Time) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE login_attempts SET blocked_until = GREATEST(blocked_until, $3) WHERE kind = $1 AND key = $2`,
+		key.Kind, hashLoginAttemptKey(key), until,
+	)
+	return err
+}
+
+// Reset forgets the failed logins of the key.
+func (r *LoginAttemptRepository) Reset(ctx context.Context, key model.LoginAttemptKey) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE kind = $1 AND key = $2`, key.Kind, hashLoginAttemptKey(key))
+	return err
+}
+
+// ForgetFailure takes back one failure counted for the key. A block already set stays.
+func (r *LoginAttemptRepository) ForgetFailure(ctx context.Context, key model.LoginAttemptKey) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE login_attempts SET failures = GREATEST(failures - 1, 0) WHERE kind = $1 AND key = $2`,
+		key.Kind, hashLoginAttemptKey(key),
+	)
+	return err
+}
+
+// DeleteExpired removes counters of the given kinds that have neither recent failures nor an active block.
+func (r *LoginAttemptRepository) DeleteExpired(ctx context.Context, kinds []model.LoginAttemptKind, window time.Duration) (int64, error) {
+	kindNames := make([]string, len(kinds))
+	for i, kind := range kinds {
+		kindNames[i] = string(kind)
+	}
+
+	result, err := r.db.ExecContext(ctx,
+		`DELETE FROM login_attempts
+		WHERE kind = ANY($1) AND last_failure_at < now() - $2 * interval '1 second'
+			AND (blocked_until IS NULL OR blocked_until < now())`,
+		kindNames, window.Seconds(),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// hashLoginAttemptKey stores keys as a hash, so a login of any length fits the column
+// and the table does not keep the logins that were tried.
+func hashLoginAttemptKey(key model.LoginAttemptKey) string {
+	sum := sha256.Sum256([]byte(key.Key))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,17 +1,32 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/cursor"
+	"time"
 )
 
 var (
 	ErrOrderAlreadyExists = errors.New("order already exists")
 	ErrNoOrdersFound      = errors.New("no orders found")
+	ErrOrderNotFound      = errors.New("order not found")
+
+	ErrOrderStatusUnchanged    = errors.New("order status unchanged")
+	ErrIllegalStatusTransition = errors.New("illegal order status transition")
+	ErrOrderLeaseLost          = errors.New("order is leased by another instance")
+)
+
+// createOrderQuery inserts a NEW order together with the first entry of its status history.
+const createOrderQuery = `WITH created AS (
+	INSERT INTO orders (number, user_id, status) VALUES ($1, $2, $3) RETURNING id, status, uploaded_at
 )
+INSERT INTO order_status_history (order_id, status, changed_at) SELECT id, status, uploaded_at FROM created`
 
 type OrderRepository struct {
 	db *sql.DB
@@ -21,29 +36,146 @@ func NewOrderRepository(db *sql.DB) *OrderRepository {
 	return &OrderRepository{db: db}
 }
 
-func (r *OrderRepository) UpdateOrderByNumber(tx *sql.Tx, accrual float64, status string, number string) (*model.Order, error) {
-	row := tx.QueryRow(`UPDATE orders SET accrual=$1, status=$2 WHERE number=$3 RETURNING id, number, status, accrual, user_id, uploaded_at`, accrual, status, number)
+// UpdateOrdersByNumber applies several status updates with a single UPDATE statement. Every update goes through
+// the order status state machine, in the given order; updates that do not pass are left out.
+// The returned rejections are parallel to updates: nil for an applied update, otherwise ErrOrderNotFound,
+// ErrOrderStatusUnchanged or ErrIllegalStatusTransition. Every applied update is also written to the order status history,
+// including those of an order that a later update of the batch moved on from.
+//
+// Updates polled under a lease pass the instance as claimedBy: an order whose lease has since been taken over
+// by another instance is rejected with ErrOrderLeaseLost, so a late response cannot overwrite a newer one.
+// An empty claimedBy, as for pushed results, applies the updates whoever holds the lease.
+func (r *OrderRepository) UpdateOrdersByNumber(ctx context.Context, tx *sql.Tx, updates []model.OrderUpdate, claimedBy string) ([]model.Order, []error, error) {
+	requested := make([]string, len(updates))
+	for i, update := range updates {
+		requested[i] = update.Number
+	}
 
-	var order model.Order
-	err := row.Scan(&order.ID, &order.Number, &order.Status, &order.Accrual, &order.UserID, &order.UploadedAt)
+	rows, err := tx.QueryContext(ctx, `SELECT number, status, claimed_by FROM orders WHERE number = ANY($1) ORDER BY id FOR UPDATE`, requested)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return &order, nil
-}
+	defer rows.Close()
 
-func (r *OrderRepository) GetAllNotTerminated() ([]model.Order, error) {
-	rows, err := r.db.Query(`SELECT id, number, status, user_id, accrual, uploaded_at FROM orders WHERE status not in ('INVALID','PROCESSED')`)
-	if err != nil {
-		return nil, err
+	current := make(map[string]model.OrderStatus, len(updates))
+	leasedByOther := make(map[string]bool)
+	for rows.Next() {
+		var number string
+		var status model.OrderStatus
+		var holder sql.NullString
+
+		err = rows.Scan(&number, &status, &holder)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		current[number] = status
+		leasedByOther[number] = claimedBy != "" && holder.Valid && holder.String != claimedBy
 	}
 
-	err = rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rejected := make([]error, len(updates))
+	var numbers, statuses []string
+	var accruals []int64
+	for i, update := range updates {
+		status, found := current[update.Number]
+		switch {
+		case !found:
+			rejected[i] = ErrOrderNotFound
+			continue
+		case leasedByOther[update.Number]:
+			rejected[i] = ErrOrderLeaseLost
+			continue
+		case status == update.Status:
+			rejected[i] = ErrOrderStatusUnchanged
+			continue
+		case !status.CanTransitionTo(update.Status):
+			rejected[i] = fmt.Errorf("%w: %s -> %s", ErrIllegalStatusTransition, status, update.Status)
+			continue
+		}
+
+		// Later updates of the same order in one batch are compared with the status set by the earlier one
+		current[update.Number] = update.Status
+
+		accrual := update.Accrual
+		if update.Status != model.Processed {
+			accrual = 0
+		}
+
+		numbers = append(numbers, update.Number)
+		statuses = append(statuses, string(update.Status))
+		accruals = append(accruals, int64(accrual))
+	}
+
+	if len(numbers) == 0 {
+		return nil, rejected, nil
+	}
+
+	rows, err = tx.QueryContext(ctx,
+		`WITH transitions AS (
+			SELECT * FROM unnest($1::TEXT[], $2::TEXT[], $3::BIGINT[]) WITH ORDINALITY AS t(number, status, accrual, position)
+		), updated AS (
+			UPDATE orders o
+			SET accrual = u.accrual, status = u.status, attempts = 0, next_poll_at = now(), claimed_by = NULL, lease_until = NULL
+			FROM (
+				SELECT DISTINCT ON (number) number, status, accrual FROM transitions ORDER BY number, position DESC
+			) u
+			WHERE o.number = u.number
+			RETURNING o.id, o.number, o.status, o.accrual, o.user_id, o.uploaded_at
+		), history AS (
+			INSERT INTO order_status_history (order_id, status, accrual)
+			SELECT updated.id, t.status, t.accrual FROM transitions t JOIN updated ON updated.number = t.number ORDER BY t.position
+		)
+		SELECT id, number, status, accrual, user_id, uploaded_at FROM updated`,
+		numbers, statuses, accruals,
+	)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrNoOrdersFound
+		return nil, nil, err
+	}
+
+	defer rows.Close()
+
+	var orders []model.Order
+	for rows.Next() {
+		var order model.Order
+
+		err = rows.Scan(&order.ID, &order.Number, &order.Status, &order.Accrual, &order.UserID, &order.UploadedAt)
+		if err != nil {
+			return nil, nil, err
 		}
+
+		orders = append(orders, order)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return orders, rejected, nil
+}
+
+// ClaimNotTerminated leases up to limit pending orders that are due for polling to claimedBy. Orders locked by
+// another transaction or leased by another instance are skipped, so concurrent replicas never poll the same order.
+func (r *OrderRepository) ClaimNotTerminated(ctx context.Context, claimedBy string, limit int, lease time.Duration) ([]model.Order, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`UPDATE orders SET claimed_by = $1, lease_until = now() + make_interval(secs => $2::FLOAT8)
+		WHERE id IN (
+			SELECT id FROM orders
+			WHERE status NOT IN ('INVALID', 'PROCESSED', 'STALE')
+				AND next_poll_at <= now()
+				AND (lease_until IS NULL OR lease_until < now())
+			ORDER BY next_poll_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, number, status, user_id, accrual, uploaded_at, attempts`,
+		claimedBy, lease.Seconds(), limit,
+	)
+	if err != nil {
 		return nil, err
 	}
 
@@ -53,7 +185,7 @@ func (r *OrderRepository) GetAllNotTerminated() ([]model.Order, error) {
 	for rows.Next() {
 		var order model.Order
 
-		err = rows.Scan(&order.ID, &order.Number, &order.Status, &order.UserID, &order.Accrual, &order.UploadedAt)
+		err = rows.Scan(&order.ID, &order.Number, &order.Status, &order.UserID, &order.Accrual, &order.UploadedAt, &order.Attempts)
 		if err != nil {
 			return nil, err
 		}
@@ -68,8 +200,34 @@ func (r *OrderRepository) GetAllNotTerminated() ([]model.Order, error) {
 	return orders, nil
 }
 
-func (r *OrderRepository) CreateOrder(orderNumber string, userID int) error {
-	_, err := r.db.Exec(`INSERT INTO orders (number, user_id, status) VALUES ($1, $2, $3)`, orderNumber, userID, model.New)
+// ScheduleNextPoll records an unsuccessful poll and releases the lease until the order is due again.
+func (r *OrderRepository) ScheduleNextPoll(ctx context.Context, orderNumber string, claimedBy string, delay time.Duration) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE orders SET attempts = attempts + 1, next_poll_at = now() + make_interval(secs => $1::FLOAT8), claimed_by = NULL, lease_until = NULL
+		WHERE number = $2 AND claimed_by = $3`,
+		delay.Seconds(), orderNumber, claimedBy,
+	)
+	return err
+}
+
+// PostponePoll releases the lease until the order is due again without counting the poll as an attempt,
+// for polls that failed on the side of the accrual system rather than found the order unchanged.
+func (r *OrderRepository) PostponePoll(ctx context.Context, orderNumber string, claimedBy string, delay time.Duration) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE orders SET next_poll_at = now() + make_interval(secs => $1::FLOAT8), claimed_by = NULL, lease_until = NULL
+		WHERE number = $2 AND claimed_by = $3`,
+		delay.Seconds(), orderNumber, claimedBy,
+	)
+	return err
+}
+
+func (r *OrderRepository) ReleaseOrder(ctx context.Context, orderNumber string, claimedBy string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE orders SET claimed_by = NULL, lease_until = NULL WHERE number = $1 AND claimed_by = $2`, orderNumber, claimedBy)
+	return err
+}
+
+func (r *OrderRepository) CreateOrder(ctx context.Context, orderNumber string, userID int) error {
+	_, err := r.db.ExecContext(ctx, createOrderQuery, orderNumber, userID, model.New)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
@@ -81,8 +239,8 @@ func (r *OrderRepository) CreateOrder(orderNumber string, userID int) error {
 	return nil
 }
 
-func (r *OrderRepository) CreateOrderInTransaction(tx *sql.Tx, orderNumber string, userID int) error {
-	_, err := tx.Exec(`INSERT INTO orders (number, user_id, status) VALUES ($1, $2, $3)`, orderNumber, userID, model.New)
+func (r *OrderRepository) CreateOrderInTransaction(ctx context.Context, tx *sql.Tx, orderNumber string, userID int) error {
+	_, err := tx.ExecContext(ctx, createOrderQuery, orderNumber, userID, model.New)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
@@ -94,33 +252,136 @@ func (r *OrderRepository) CreateOrderInTransaction(tx *sql.Tx, orderNumber strin
 	return nil
 }
 
-func (r *OrderRepository) GetOrder(orderNumber string) (*model.Order, error) {
-	row := r.db.QueryRow(`SELECT id, number, status, user_id, accrual, uploaded_at FROM orders WHERE number = $1`, orderNumber)
+// CreateOrdersInTransaction inserts NEW orders for the user with their first status history entries, skipping numbers
+// that already exist. It returns the numbers actually inserted.
+func (r *OrderRepository) CreateOrdersInTransaction(ctx context.Context, tx *sql.Tx, orderNumbers []string, userID int) ([]string, error) {
+	rows, err := tx.QueryContext(ctx,
+		`WITH created AS (
+			INSERT INTO orders (number, user_id, status)
+			SELECT number, $2, $3 FROM unnest($1::TEXT[]) AS t(number)
+			ON CONFLICT (number) DO NOTHING
+			RETURNING id, number, status, uploaded_at
+		), history AS (
+			INSERT INTO order_status_history (order_id, status, changed_at)
+			SELECT id, status, uploaded_at FROM created
+		)
+		SELECT number FROM created`,
+		orderNumbers, userID, model.New,
+	)
+	if err != nil {
+		return nil, err
+	}
 
-	var order model.Order
+	defer rows.Close()
 
-	err := row.Scan(&order.ID, &order.Number, &order.Status, &order.UserID, &order.Accrual, &order.UploadedAt)
+	var created []string
+	for rows.Next() {
+		var number string
+
+		err = rows.Scan(&number)
+		if err != nil {
+			return nil, err
+		}
+
+		created = append(created, number)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// GetOrderOwners returns the owner of each of the given orders that exists, keyed by order number.
+func (r *OrderRepository) GetOrderOwners(ctx context.Context, tx *sql.Tx, orderNumbers []string) (map[string]int, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT number, user_id FROM orders WHERE number = ANY($1)`, orderNumbers)
 	if err != nil {
 		return nil, err
 	}
 
-	return &order, nil
+	defer rows.Close()
+
+	owners := make(map[string]int, len(orderNumbers))
+	for rows.Next() {
+		var number string
+		var userID int
+
+		err = rows.Scan(&number, &userID)
+		if err != nil {
+			return nil, err
+		}
+
+		owners[number] = userID
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return owners, nil
 }
 
-func (r *OrderRepository) GetOrders(userID int) ([]model.Order, error) {
-	rows, err := r.db.Query(`SELECT id, number, status, user_id, accrual, uploaded_at FROM orders WHERE user_id=$1 order by uploaded_at`, userID)
+func (r *OrderRepository) GetOrder(ctx context.Context, orderNumber string) (*model.Order, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT id, number, status, user_id, accrual, uploaded_at FROM orders WHERE number = $1`, orderNumber)
+
+	var order model.Order
+
+	err := row.Scan(&order.ID, &order.Number, &order.Status, &order.UserID, &order.Accrual, &order.UploadedAt)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
 		return nil, err
 	}
 
-	err = rows.Err()
+	return &order, nil
+}
+
+// GetUserOrder returns an order only if it belongs to the user. Unknown orders and orders of other users
+// both give ErrOrderNotFound through the same query, so the caller cannot tell them apart.
+func (r *OrderRepository) GetUserOrder(ctx context.Context, orderNumber string, userID int) (*model.Order, error) {
+	row := r.db.QueryRowContext(ctx,
+		`SELECT id, number, status, user_id, accrual, uploaded_at FROM orders WHERE number = $1 AND user_id = $2`,
+		orderNumber, userID,
+	)
+
+	var order model.Order
+
+	err := row.Scan(&order.ID, &order.Number, &order.Status, &order.UserID, &order.Accrual, &order.UploadedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrNoOrdersFound
+			return nil, ErrOrderNotFound
 		}
 		return nil, err
 	}
 
+	return &order, nil
+}
+
+// GetOrders returns one page of the user's orders and the cursor of the next page, nil on the last page.
+// An empty page gives ErrNoOrdersFound.
+func (r *OrderRepository) GetOrders(ctx context.Context, userID int, query model.OrderQuery) ([]model.Order, *cursor.Cursor, error) {
+	sqlQuery := `SELECT id, number, status, user_id, accrual, uploaded_at FROM orders WHERE user_id = $1`
+	args := []any{userID}
+
+	if len(query.Statuses) > 0 {
+		statuses := make([]string, len(query.Statuses))
+		for i, status := range query.Statuses {
+			statuses[i] = string(status)
+		}
+
+		args = append(args, statuses)
+		sqlQuery += fmt.Sprintf(" AND status = ANY($%d)", len(args))
+	}
+
+	sqlQuery, args = appendPageClause(sqlQuery, args, "uploaded_at", query.PageQuery)
+
+	rows, err := r.db.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	defer rows.Close()
 
 	var orders []model.Order
@@ -129,15 +390,54 @@ func (r *OrderRepository) GetOrders(userID int) ([]model.Order, error) {
 
 		err = rows.Scan(&order.ID, &order.Number, &order.Status, &order.UserID, &order.Accrual, &order.UploadedAt)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		orders = append(orders, order)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(orders) == 0 {
+		return nil, nil, ErrNoOrdersFound
+	}
+
+	orders, next := nextCursor(orders, query.Limit, func(order model.Order) cursor.Cursor {
+		return cursor.New(order.UploadedAt, order.ID)
+	})
+
+	return orders, next, nil
+}
+
+// GetStatusHistory returns the statuses an order went through, oldest first.
+func (r *OrderRepository) GetStatusHistory(ctx context.Context, orderID int) ([]model.OrderStatusChange, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT status, accrual, changed_at FROM order_status_history WHERE order_id = $1 ORDER BY changed_at, id`,
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var history []model.OrderStatusChange
+	for rows.Next() {
+		var change model.OrderStatusChange
+
+		err = rows.Scan(&change.Status, &change.Accrual, &change.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		history = append(history, change)
+	}
+
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 
-	return orders, nil
+	return history, nil
 }
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/cursor"
+)
+
+// appendPageClause adds the date range and keyset conditions of a page on (timeColumn, id) to a query that already
+// has a WHERE clause, followed by ORDER BY and LIMIT. One row more than the page is requested, see nextCursor.
+func appendPageClause(query string, args []any, timeColumn string, page model.PageQuery) (string, []any) {
+	if !page.From.IsZero() {
+		args = append(args, page.From)
+		query += fmt.Sprintf(" AND %s >= $%d", timeColumn, len(args))
+	}
+
+	if !page.To.IsZero() {
+		args = append(args, page.To)
+		query += fmt.Sprintf(" AND %s < $%d", timeColumn, len(args))
+	}
+
+	direction, comparison := "ASC", ">"
+	if page.Sort == model.SortDescending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if page.After != nil {
+		args = append(args, page.After.Time, page.After.ID)
+		query += fmt.Sprintf(" AND (%s, id) %s ($%d, $%d)", timeColumn, comparison, len(args)-1, len(args))
+	}
+
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s", timeColumn, direction, direction)
+	if page.Limit > 0 {
+		args = append(args, page.Limit+1)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	return query, args
+}
+
+// nextCursor trims the extra row requested by appendPageClause and returns the cursor of the next page,
+// or nil if this page is the last one.
+func nextCursor[T any](rows []T, limit int, position func(T) cursor.Cursor) ([]T, *cursor.Cursor) {
+	if limit == 0 || len(rows) <= limit {
+		return rows, nil
+	}
+
+	rows = rows[:limit]
+	next := position(rows[limit-1])
+
+	return rows, &next
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+)
+
+var (
+	ErrPasswordResetTokenNotFound = errors.New("password reset token not found")
+)
+
+type PasswordResetRepository struct {
+	db *sql.DB
+}
+
+func NewPasswordResetRepository(db *sql.DB) *PasswordResetRepository {
+	return &PasswordResetRepository{db: db}
+}
+
+// CreateResetToken stores a new reset token of the user. Tokens requested before are invalidated,
+// so only the latest one works, and expired tokens of all users are cleaned up on the way.
+func (r *PasswordResetRepository) CreateResetToken(ctx context.Context, tx *sql.Tx, token *model.PasswordResetToken) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM password_reset_tokens WHERE expires_at < now()`)
+	if err != nil {
+		return err
+	}
+
+	err = r.InvalidateResetTokens(ctx, tx, token.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		token.UserID, token.TokenHash, token.ExpiresAt,
+	)
+	return err
+}
+
+func (r *PasswordResetRepository) GetResetTokenForUpdate(ctx context.Context, tx *sql.Tx, tokenHash string) (*model.PasswordResetToken, error) {
+	row := tx.QueryRowContext(ctx,
+		`SELECT id, user_id, token_hash, created_at, expires_at, used_at
+		FROM password_reset_tokens WHERE token_hash = $1 FOR UPDATE`,
+		tokenHash,
+	)
+
+	var token model.PasswordResetToken
+	err := row.Scan(&token.ID, &token.UserID, &token.TokenHash, &token.CreatedAt, &token.ExpiresAt, &token.UsedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPasswordResetTokenNotFound
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// InvalidateResetTokens marks every unused reset token of the user as used.
+func (r *PasswordResetRepository) InvalidateResetTokens(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE password_reset_tokens SET used_at = now() WHERE user_id = $1 AND used_at IS NULL`,
+		userID,
+	)
+	return err
+}
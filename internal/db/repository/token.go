@@ -0,0 +1,120 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"time"
+)
+
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+)
+
+type TokenRepository struct {
+	db *sql.DB
+}
+
+func NewTokenRepository(db *sql.DB) *TokenRepository {
+	return &TokenRepository{db: db}
+}
+
+func (r *TokenRepository) CreateRefreshToken(ctx context.Context, tx *sql.Tx, token *model.RefreshToken) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)`,
+		token.UserID, token.FamilyID, token.TokenHash, token.ExpiresAt,
+	)
+	return err
+}
+
+func (r *TokenRepository) GetRefreshTokenForUpdate(ctx context.Context, tx *sql.Tx, tokenHash string) (*model.RefreshToken, error) {
+	row := tx.QueryRowContext(ctx,
+		`SELECT id, user_id, family_id, token_hash, created_at, expires_at, used_at, revoked_at
+		FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`,
+		tokenHash,
+	)
+
+	var token model.RefreshToken
+	err := row.Scan(
+		&token.ID, &token.UserID, &token.FamilyID, &token.TokenHash,
+		&token.CreatedAt, &token.ExpiresAt, &token.UsedAt, &token.RevokedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRefreshTokenNotFound
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (r *TokenRepository) MarkRefreshTokenUsed(ctx context.Context, tx *sql.Tx, tokenID int64) error {
+	_, err := tx.ExecContext(ctx, `UPDATE refresh_tokens SET used_at = now() WHERE id = $1`, tokenID)
+	return err
+}
+
+// RevokeRefreshTokenFamily revokes every refresh token rotated from the same login.
+func (r *TokenRepository) RevokeRefreshTokenFamily(ctx context.Context, tx *sql.Tx, familyID string) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL`,
+		familyID,
+	)
+	return err
+}
+
+// RevokeAccessToken puts an access token ID on the denylist until the token expires anyway.
+func (r *TokenRepository) RevokeAccessToken(ctx context.Context, tx *sql.Tx, jti string, expiresAt time.Time) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt,
+	)
+	return err
+}
+
+// DeleteExpiredRefreshTokens removes the refresh token families whose tokens have all expired. A family is kept
+// as long as one of its tokens is valid, so presenting an old token of it is still detected as reuse.
+func (r *TokenRepository) DeleteExpiredRefreshTokens(ctx context.Context) (int64, error) {
+	result, err := r.db.ExecContext(ctx,
+		`DELETE FROM refresh_tokens t
+		WHERE t.expires_at < now()
+			AND NOT EXISTS (SELECT 1 FROM refresh_tokens f WHERE f.family_id = t.family_id AND f.expires_at >= now())`,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteExpiredRevokedAccessTokens removes denylist entries of access tokens that have expired anyway.
+func (r *TokenRepository) DeleteExpiredRevokedAccessTokens(ctx context.Context) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < now()`)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// RevokeUserRefreshTokens revokes every refresh token of the user.
+func (r *TokenRepository) RevokeUserRefreshTokens(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`,
+		userID,
+	)
+	return err
+}
+
+// IsAccessTokenRevoked reports whether the token ID is on the denylist or the token was issued
+// with another token version than the current one of the user.
+func (r *TokenRepository) IsAccessTokenRevoked(ctx context.Context, jti string, userID int, tokenVersion int) (bool, error) {
+	var revoked bool
+	err := r.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+			OR NOT EXISTS (SELECT 1 FROM users WHERE id = $2 AND token_version = $3)`,
+		jti, userID, tokenVersion,
+	).Scan(&revoked)
+	return revoked, err
+}
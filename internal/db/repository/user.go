@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"github.com/jackc/pgerrcode"
@@ -11,6 +12,7 @@ import (
 
 var (
 	ErrUserAlreadyExists = errors.New("user already exist")
+	ErrUserNotFound      = errors.New("user not found")
 )
 
 type UserRepository struct {
@@ -21,8 +23,8 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-func (r *UserRepository) CreateUser(tx *sql.Tx, login string, password string) (*model.User, error) {
-	row := r.db.QueryRow(`INSERT INTO users (login, password) VALUES ($1, $2) RETURNING *`, login, password)
+func (r *UserRepository) CreateUser(ctx context.Context, tx *sql.Tx, login string, password string) (*model.User, error) {
+	row := tx.QueryRowContext(ctx, `INSERT INTO users (login, password) VALUES ($1, $2) RETURNING id, login, password`, login, password)
 
 	var user model.User
 	err := row.Scan(&user.ID, &user.Login, &user.Password)
@@ -37,15 +39,106 @@ func (r *UserRepository) CreateUser(tx *sql.Tx, login string, password string) (
 	return &user, nil
 }
 
-func (r *UserRepository) GetUserByLogin(login string) (*model.User, error) {
-	row := r.db.QueryRow(`SELECT * FROM users WHERE login = $1`, login)
+func (r *UserRepository) GetUserByLogin(ctx context.Context, login string) (*model.User, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT id, login, password FROM users WHERE login = $1`, login)
 
 	var user model.User
 	err := row.Scan(&user.ID, &user.Login, &user.Password)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
 		zap.L().Error("Failed to query user by login", zap.String("login", login), zap.Error(err))
 		return nil, err
 	}
 
 	return &user, nil
 }
+
+func (r *UserRepository) GetUserByID(ctx context.Context, userID int) (*model.User, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT id, login, password FROM users WHERE id = $1`, userID)
+
+	var user model.User
+	err := row.Scan(&user.ID, &user.Login, &user.Password)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// UpdatePassword sets a new password hash and bumps the token version, so tokens issued before stop working.
+func (r *UserRepository) UpdatePassword(ctx context.Context, tx *sql.Tx, userID int, password string) error {
+	result, err := tx.ExecContext(ctx,
+		`UPDATE users SET password = $2, token_version = token_version + 1 WHERE id = $1`,
+		userID, password,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// ReplacePasswordHash swaps a hash of the same password for a stronger one. Tokens stay valid, and nothing
+// is changed if the password has been changed since currentHash was read.
+func (r *UserRepository) ReplacePasswordHash(ctx context.Context, userID int, currentHash string, newHash string) error {
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE users SET password = $3 WHERE id = $1 AND password = $2`,
+		userID, currentHash, newHash,
+	)
+	return err
+}
+
+// GetPasswordForUpdate returns the password hash of the user and locks the row until the end of the transaction,
+// for a caller that is about to change the password.
+func (r *UserRepository) GetPasswordForUpdate(ctx context.Context, tx *sql.Tx, userID int) (string, error) {
+	var password string
+	err := tx.QueryRowContext(ctx, `SELECT password FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&password)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrUserNotFound
+		}
+		return "", err
+	}
+
+	return password, nil
+}
+
+// GetPasswordForShare returns the password hash of the user and keeps it from being changed until the end of the transaction.
+func (r *UserRepository) GetPasswordForShare(ctx context.Context, tx *sql.Tx, userID int) (string, error) {
+	var password string
+	err := tx.QueryRowContext(ctx, `SELECT password FROM users WHERE id = $1 FOR SHARE`, userID).Scan(&password)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrUserNotFound
+		}
+		return "", err
+	}
+
+	return password, nil
+}
+
+func (r *UserRepository) GetTokenVersion(ctx context.Context, tx *sql.Tx, userID int) (int, error) {
+	var version int
+	err := tx.QueryRowContext(ctx, `SELECT token_version FROM users WHERE id = $1`, userID).Scan(&version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrUserNotFound
+		}
+		return 0, err
+	}
+
+	return version, nil
+}
@@ -1,9 +1,11 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/cursor"
 )
 
 var (
@@ -18,43 +20,59 @@ func NewWithdrawalRepository(db *sql.DB) *WithdrawalRepository {
 	return &WithdrawalRepository{db: db}
 }
 
-func (r *WithdrawalRepository) GetWithdrawals(userID int) ([]model.Withdrawal, error) {
-	var withdrawals []model.Withdrawal
+// GetWithdrawals returns one page of the user's withdrawals and the cursor of the next page, nil on the last page.
+// An empty page gives ErrNoWithdrawalsFound.
+func (r *WithdrawalRepository) GetWithdrawals(ctx context.Context, userID int, page model.PageQuery) ([]model.Withdrawal, *cursor.Cursor, error) {
+	query, args := appendPageClause(
+		`SELECT id, user_id, order_number, sum, processed_at FROM withdrawals WHERE user_id = $1`,
+		[]any{userID}, "processed_at", page,
+	)
 
-	rows, err := r.db.Query(`SELECT id, user_id, order_number, sum, processed_at FROM withdrawals WHERE user_id = $1 ORDER BY processed_at;`, userID)
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
-	}
-
-	err = rows.Err()
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, ErrNoWithdrawalsFound
-		}
-		return nil, err
+		return nil, nil, err
 	}
 
 	defer rows.Close()
 
+	var withdrawals []model.Withdrawal
 	for rows.Next() {
 		var withdrawal model.Withdrawal
 
 		err = rows.Scan(&withdrawal.ID, &withdrawal.UserID, &withdrawal.OrderNumber, &withdrawal.Sum, &withdrawal.ProcessedAt)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		withdrawals = append(withdrawals, withdrawal)
 	}
 
 	if err := rows.Err(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return withdrawals, nil
+	if len(withdrawals) == 0 {
+		return nil, nil, ErrNoWithdrawalsFound
+	}
+
+	withdrawals, next := nextCursor(withdrawals, page.Limit, func(withdrawal model.Withdrawal) cursor.Cursor {
+		return cursor.New(withdrawal.ProcessedAt, withdrawal.ID)
+	})
+
+	return withdrawals, next, nil
 }
 
-func (r *WithdrawalRepository) CreateWithdrawal(tx *sql.Tx, userID int, orderNumber string, sum float64) error {
-	_, err := tx.Exec(`INSERT INTO withdrawals (user_id, order_number, sum) VALUES ($1, $2, $3)`, userID, orderNumber, sum)
-	return err
+func (r *WithdrawalRepository) CreateWithdrawal(ctx context.Context, tx *sql.Tx, userID int, orderNumber string, sum model.Amount) (*model.Withdrawal, error) {
+	row := tx.QueryRowContext(ctx,
+		`INSERT INTO withdrawals (user_id, order_number, sum) VALUES ($1, $2, $3) RETURNING id, user_id, order_number, sum, processed_at`,
+		userID, orderNumber, sum,
+	)
+
+	var withdrawal model.Withdrawal
+	err := row.Scan(&withdrawal.ID, &withdrawal.UserID, &withdrawal.OrderNumber, &withdrawal.Sum, &withdrawal.ProcessedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &withdrawal, nil
 }
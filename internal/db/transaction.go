@@ -1,7 +1,9 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"go.uber.org/zap"
 )
 
@@ -15,15 +17,15 @@ func NewTransactionManager(db *sql.DB) *TransactionManager {
 
 type TxFunc func(tx *sql.Tx) (interface{}, error)
 
-func (tm *TransactionManager) RunInTransaction(txFunc TxFunc) (interface{}, error) {
-	tx, err := tm.db.Begin()
+func (tm *TransactionManager) RunInTransaction(ctx context.Context, txFunc TxFunc) (interface{}, error) {
+	tx, err := tm.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	result, err := txFunc(tx)
 	if err != nil {
-		if rbErr := tx.Rollback(); rbErr != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
 			zap.L().Error("Failed to rollback transaction", zap.Error(rbErr))
 		}
 		return nil, err
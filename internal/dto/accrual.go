@@ -1,7 +1,26 @@
 package dto
 
+import "github.com/zavtra-na-rabotu/gophermart/internal/model"
+
+type AccrualStatus string
+
+const (
+	AccrualRegistered AccrualStatus = "REGISTERED"
+	AccrualInvalid    AccrualStatus = "INVALID"
+	AccrualProcessing AccrualStatus = "PROCESSING"
+	AccrualProcessed  AccrualStatus = "PROCESSED"
+)
+
+func (s AccrualStatus) Valid() bool {
+	switch s {
+	case AccrualRegistered, AccrualInvalid, AccrualProcessing, AccrualProcessed:
+		return true
+	}
+	return false
+}
+
 type AccrualOrderResponse struct {
-	Order   string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float64 `json:"accrual"`
+	Order   string        `json:"order"`
+	Status  AccrualStatus `json:"status"`
+	Accrual model.Amount  `json:"accrual"`
 }
@@ -1,6 +1,8 @@
 package dto
 
+import "github.com/zavtra-na-rabotu/gophermart/internal/model"
+
 type GetBalanceResponse struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Current   model.Amount `json:"current"`
+	Withdrawn model.Amount `json:"withdrawn"`
 }
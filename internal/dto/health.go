@@ -0,0 +1,7 @@
+package dto
+
+type HealthResponse struct {
+	Status        string `json:"status"`
+	Database      string `json:"database"`
+	AccrualSystem string `json:"accrual_system"`
+}
@@ -0,0 +1,9 @@
+package dto
+
+import (
+	"github.com/zavtra-na-rabotu/gophermart/internal/security"
+)
+
+type JWKSResponse struct {
+	Keys []security.JWK `json:"keys"`
+}
@@ -7,6 +7,25 @@ import (
 type GetOrdersResponse struct {
 	Number     string            `json:"number"`
 	Status     model.OrderStatus `json:"status"`
-	Accrual    float64           `json:"accrual,omitempty"`
+	Accrual    model.Amount      `json:"accrual,omitempty"`
 	UploadedAt string            `json:"uploaded_at"`
 }
+
+type GetOrderResponse struct {
+	Number     string                `json:"number"`
+	Status     model.OrderStatus     `json:"status"`
+	Accrual    model.Amount          `json:"accrual,omitempty"`
+	UploadedAt string                `json:"uploaded_at"`
+	History    []OrderStatusResponse `json:"history"`
+}
+
+type OrderStatusResponse struct {
+	Status    model.OrderStatus `json:"status"`
+	Accrual   model.Amount      `json:"accrual,omitempty"`
+	ChangedAt string            `json:"changed_at"`
+}
+
+type CreateOrdersResponse struct {
+	Number string                  `json:"number"`
+	Result model.OrderUploadResult `json:"result"`
+}
@@ -5,11 +5,17 @@ type RegisterUserRequest struct {
 	Password string `json:"password"`
 }
 
-type RegisterUserResponse struct {
-	Token string `json:"token"`
-}
-
 type LoginUserRequest struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
 }
+
+type FieldErrorResponse struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationErrorResponse struct {
+	Error  string               `json:"error"`
+	Errors []FieldErrorResponse `json:"errors"`
+}
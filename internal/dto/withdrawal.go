@@ -1,12 +1,14 @@
 package dto
 
+import "github.com/zavtra-na-rabotu/gophermart/internal/model"
+
 type CreateWithdrawalRequest struct {
-	Order string  `json:"order"`
-	Sum   float64 `json:"sum"`
+	Order string       `json:"order"`
+	Sum   model.Amount `json:"sum"`
 }
 
 type GetWithdrawalsResponse struct {
-	Order       string  `json:"order"`
-	Sum         float64 `json:"sum"`
-	ProcessedAt string  `json:"processed_at"`
+	Order       string       `json:"order"`
+	Sum         model.Amount `json:"sum"`
+	ProcessedAt string       `json:"processed_at"`
 }
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
+	"github.com/zavtra-na-rabotu/gophermart/internal/dto"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"github.com/zavtra-na-rabotu/gophermart/internal/security"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+)
+
+const (
+	accrualTimestampHeader = "X-Accrual-Timestamp"
+	accrualSignatureHeader = "X-Accrual-Signature"
+	maxCallbackBodySize    = 64 << 10
+)
+
+// accrualEventReceiver is the part of service.OrderService the handler works with.
+type accrualEventReceiver interface {
+	ReceiveAccrualEvent(ctx context.Context, event model.AccrualEvent) error
+}
+
+type AccrualCallbackHandler struct {
+	orderService      accrualEventReceiver
+	signatureVerifier *security.SignatureVerifier
+}
+
+func NewAccrualCallbackHandler(orderService accrualEventReceiver, signatureVerifier *security.SignatureVerifier) *AccrualCallbackHandler {
+	return &AccrualCallbackHandler{orderService: orderService, signatureVerifier: signatureVerifier}
+}
+
+// HandleCallback records an order status pushed by the accrual system as an accrual event and applies it.
+// Statuses go through the same transitions as polled ones, so a push and a poll of the same result are idempotent.
+func (h *AccrualCallbackHandler) HandleCallback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCallbackBodySize))
+		if err != nil {
+			http.Error(w, "Failed to read body", http.StatusBadRequest)
+			return
+		}
+
+		err = h.signatureVerifier.Verify(body, r.Header.Get(accrualTimestampHeader), r.Header.Get(accrualSignatureHeader))
+		if err != nil {
+			zap.L().Warn("Rejected accrual callback", zap.String("remoteAddr", r.RemoteAddr), zap.Error(err))
+			http.Error(w, "Invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		var request dto.AccrualOrderResponse
+		if err := json.Unmarshal(body, &request); err != nil {
+			zap.L().Error("Failed to parse body", zap.Error(err))
+			http.Error(w, "Failed to parse body", http.StatusBadRequest)
+			return
+		}
+
+		if request.Order == "" || !request.Status.Valid() || request.Accrual < 0 {
+			http.Error(w, "Invalid accrual status", http.StatusBadRequest)
+			return
+		}
+
+		err = h.orderService.ReceiveAccrualEvent(r.Context(), model.AccrualEvent{
+			OrderNumber: request.Order,
+			Source:      model.CallbackSource,
+			Status:      string(request.Status),
+			Accrual:     request.Accrual,
+		})
+		if err != nil && !errors.Is(err, repository.ErrOrderStatusUnchanged) {
+			if errors.Is(err, repository.ErrOrderNotFound) {
+				http.Error(w, "Order not found", http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, repository.ErrIllegalStatusTransition) {
+				http.Error(w, "Illegal status transition", http.StatusConflict)
+				return
+			}
+			zap.L().Error("Failed to apply accrual callback", zap.Error(err))
+			http.Error(w, "Failed to apply accrual callback", http.StatusInternalServerError)
+			return
+		}
+
+		zap.L().Info(
+			"Order status pushed",
+			zap.String("order", request.Order),
+			zap.String("status", string(request.Status)),
+			zap.Stringer("accrual", request.Accrual),
+		)
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -1,19 +1,25 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/zavtra-na-rabotu/gophermart/internal/dto"
 	"github.com/zavtra-na-rabotu/gophermart/internal/middleware"
-	"github.com/zavtra-na-rabotu/gophermart/internal/service"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
 	"go.uber.org/zap"
 	"net/http"
 )
 
+// balanceService is the part of service.BalanceService the handler works with.
+type balanceService interface {
+	GetBalance(ctx context.Context, userID int) (*model.Balance, error)
+}
+
 type BalanceHandler struct {
-	balanceService *service.BalanceService
+	balanceService balanceService
 }
 
-func NewBalanceHandler(balanceService *service.BalanceService) *BalanceHandler {
+func NewBalanceHandler(balanceService balanceService) *BalanceHandler {
 	return &BalanceHandler{balanceService: balanceService}
 }
 
@@ -21,7 +27,7 @@ func (h *BalanceHandler) GetBalance() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Context().Value(middleware.UserIDKey).(int)
 
-		balance, err := h.balanceService.GetBalance(userID)
+		balance, err := h.balanceService.GetBalance(r.Context(), userID)
 		if err != nil {
 			zap.L().Error("Failed to get balance", zap.Error(err))
 			http.Error(w, "Failed to get balance", http.StatusInternalServerError)
@@ -0,0 +1,378 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
+	"github.com/zavtra-na-rabotu/gophermart/internal/dto"
+	"github.com/zavtra-na-rabotu/gophermart/internal/middleware"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"github.com/zavtra-na-rabotu/gophermart/internal/security"
+	"github.com/zavtra-na-rabotu/gophermart/internal/service"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/cursor"
+	"github.com/zavtra-na-rabotu/gophermart/internal/validation"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The tests in this file check every endpoint against the response codes listed in SPECIFICATION.md,
+// and the endpoints added on top of it against the codes they document.
+
+var errInternal = errors.New("internal failure")
+
+type fakeUserService struct {
+	err error
+}
+
+var testTokenPair = &model.TokenPair{AccessToken: "access", AccessTokenExpiresAt: time.Now().Add(time.Hour), RefreshToken: "refresh"}
+
+func (s *fakeUserService) RegisterUser(_ context.Context, _ *dto.RegisterUserRequest) (*model.TokenPair, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return testTokenPair, nil
+}
+
+func (s *fakeUserService) LoginUser(_ context.Context, _ *dto.LoginUserRequest, _ string) (*model.TokenPair, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return testTokenPair, nil
+}
+
+type fakeTokenService struct {
+	err     error
+	revoked bool
+}
+
+func (s *fakeTokenService) Refresh(_ context.Context, _ string) (*model.TokenPair, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return testTokenPair, nil
+}
+
+func (s *fakeTokenService) Logout(_ context.Context, _ *security.CustomClaims, _ string) error {
+	return s.err
+}
+
+func (s *fakeTokenService) IsRevoked(_ context.Context, _ *security.CustomClaims) (bool, error) {
+	return s.revoked, nil
+}
+
+type fakePasswordService struct {
+	err error
+}
+
+func (s *fakePasswordService) ChangePassword(_ context.Context, _ int, _ string, _ string) error {
+	return s.err
+}
+
+func (s *fakePasswordService) RequestPasswordReset(_ context.Context, _ string, _ string) error {
+	return s.err
+}
+
+func (s *fakePasswordService) ConfirmPasswordReset(_ context.Context, _ string, _ string) error {
+	return s.err
+}
+
+type fakeOrderService struct {
+	err    error
+	orders []model.Order
+}
+
+func (s *fakeOrderService) CreateOrder(_ context.Context, _ string, _ int) error {
+	return s.err
+}
+
+func (s *fakeOrderService) CreateOrders(_ context.Context, orderNumbers []string, _ int) ([]model.OrderUpload, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	uploads := make([]model.OrderUpload, len(orderNumbers))
+	for i, orderNumber := range orderNumbers {
+		uploads[i] = model.OrderUpload{Number: orderNumber, Result: model.UploadAccepted}
+	}
+	return uploads, nil
+}
+
+func (s *fakeOrderService) ReceiveAccrualEvent(_ context.Context, _ model.AccrualEvent) error {
+	return s.err
+}
+
+func (s *fakeOrderService) GetOrders(_ context.Context, _ int, _ model.OrderQuery) ([]model.Order, *cursor.Cursor, error) {
+	return s.orders, nil, s.err
+}
+
+func (s *fakeOrderService) GetOrder(_ context.Context, _ string, _ int) (*model.Order, []model.OrderStatusChange, error) {
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+	return &s.orders[0], nil, nil
+}
+
+type fakeBalanceService struct {
+	err error
+}
+
+func (s *fakeBalanceService) GetBalance(_ context.Context, _ int) (*model.Balance, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &model.Balance{Current: 50050, Withdrawn: 4200}, nil
+}
+
+type fakeWithdrawalService struct {
+	err         error
+	withdrawals []model.Withdrawal
+}
+
+func (s *fakeWithdrawalService) GetWithdrawals(_ context.Context, _ int, _ model.PageQuery) ([]model.Withdrawal, *cursor.Cursor, error) {
+	return s.withdrawals, nil, s.err
+}
+
+func (s *fakeWithdrawalService) CreateWithdrawal(_ context.Context, _ int, _ string, _ model.Amount) error {
+	return s.err
+}
+
+type services struct {
+	user       fakeUserService
+	token      fakeTokenService
+	password   fakePasswordService
+	order      fakeOrderService
+	balance    fakeBalanceService
+	withdrawal fakeWithdrawalService
+}
+
+// callbackVerifier signs and verifies accrual callbacks in the tests.
+var callbackVerifier = security.NewSignatureVerifier([]byte("callback-secret"), time.Minute)
+
+// callbackHeaders signs an accrual callback body as sent at the given time.
+func callbackHeaders(body string, sentAt time.Time) map[string]string {
+	timestamp := strconv.FormatInt(sentAt.Unix(), 10)
+	return map[string]string{
+		accrualTimestampHeader: timestamp,
+		accrualSignatureHeader: callbackVerifier.Sign([]byte(body), timestamp),
+	}
+}
+
+// newTestRouter mounts the handlers the same way cmd/gophermart does.
+func newTestRouter(s *services, jwtService *security.JwtService) http.Handler {
+	pageLimits := PageLimits{Default: 10, Max: 100}
+	userValidator, _ := validation.NewUserValidator(validation.UserPolicy{LoginMinLength: 3, PasswordMinLength: 4})
+	userHandler := NewUserHandler(&s.user, userValidator)
+	tokenHandler := NewTokenHandler(&s.token)
+	passwordHandler := NewPasswordHandler(&s.password, userValidator)
+	orderHandler := NewOrderHandler(&s.order, pageLimits)
+	balanceHandler := NewBalanceHandler(&s.balance)
+	withdrawalHandler := NewWithdrawalHandler(&s.withdrawal, pageLimits)
+	accrualCallbackHandler := NewAccrualCallbackHandler(&s.order, callbackVerifier)
+
+	router := chi.NewRouter()
+	router.Post("/internal/accrual/callback", accrualCallbackHandler.HandleCallback())
+	router.Route("/api/user", func(r chi.Router) {
+		r.Post("/register", userHandler.RegisterUser())
+		r.Post("/login", userHandler.LoginUser())
+		r.Post("/token/refresh", tokenHandler.RefreshToken())
+		r.Post("/password/reset", passwordHandler.RequestPasswordReset())
+		r.Post("/password/reset/confirm", passwordHandler.ConfirmPasswordReset())
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.AuthorizationMiddleware(jwtService, &s.token))
+			r.Post("/logout", tokenHandler.Logout())
+			r.Post("/password", passwordHandler.ChangePassword())
+			r.Post("/orders", orderHandler.CreateOrder())
+			r.Post("/orders/batch", orderHandler.CreateOrders())
+			r.Get("/orders", orderHandler.GetOrders())
+			r.Get("/orders/{number}", orderHandler.GetOrder())
+			r.Get("/balance", balanceHandler.GetBalance())
+			r.Post("/balance/withdraw", withdrawalHandler.CreateWithdrawal())
+			r.Get("/withdrawals", withdrawalHandler.GetWithdrawals())
+		})
+	})
+
+	return router
+}
+
+func TestEndpointStatusCodes(t *testing.T) {
+	jwtService := security.NewJwtService([]byte("test"), time.Hour)
+	token, _, err := jwtService.GenerateJwtToken(1, 0)
+	if err != nil {
+		t.Fatalf("GenerateJwtToken() error = %v", err)
+	}
+
+	uploaded := time.Date(2020, 12, 10, 15, 15, 45, 0, time.UTC)
+	orders := []model.Order{{ID: 1, Number: "9278923470", Status: model.Processed, Accrual: 50000, UploadedAt: uploaded}}
+	withdrawals := []model.Withdrawal{{ID: 1, OrderNumber: "2377225624", Sum: 50000, ProcessedAt: uploaded}}
+	callbackBody := `{"order":"9278923470","status":"PROCESSED","accrual":500}`
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		headers     map[string]string
+		anonymous   bool
+		setup       func(s *services)
+		want        int
+	}{
+		{name: "Register", method: http.MethodPost, path: "/api/user/register", contentType: "application/json", body: `{"login":"user","password":"pass"}`, anonymous: true, want: http.StatusOK},
+		{name: "Register without password", method: http.MethodPost, path: "/api/user/register", contentType: "application/json", body: `{"login":"user"}`, anonymous: true, want: http.StatusBadRequest},
+		{name: "Register with short password", method: http.MethodPost, path: "/api/user/register", contentType: "application/json", body: `{"login":"user","password":"abc"}`, anonymous: true, want: http.StatusBadRequest},
+		{name: "Register with malformed body", method: http.MethodPost, path: "/api/user/register", contentType: "application/json", body: `{"login":`, anonymous: true, want: http.StatusBadRequest},
+		{name: "Register taken login", method: http.MethodPost, path: "/api/user/register", contentType: "application/json", body: `{"login":"user","password":"pass"}`, anonymous: true,
+			setup: func(s *services) { s.user.err = repository.ErrUserAlreadyExists }, want: http.StatusConflict},
+		{name: "Register failure", method: http.MethodPost, path: "/api/user/register", contentType: "application/json", body: `{"login":"user","password":"pass"}`, anonymous: true,
+			setup: func(s *services) { s.user.err = errInternal }, want: http.StatusInternalServerError},
+
+		{name: "Login", method: http.MethodPost, path: "/api/user/login", contentType: "application/json", body: `{"login":"user","password":"pass"}`, anonymous: true, want: http.StatusOK},
+		{name: "Login with malformed body", method: http.MethodPost, path: "/api/user/login", contentType: "application/json", body: `[]`, anonymous: true, want: http.StatusBadRequest},
+		{name: "Login with wrong password", method: http.MethodPost, path: "/api/user/login", contentType: "application/json", body: `{"login":"user","password":"wrong"}`, anonymous: true,
+			setup: func(s *services) { s.user.err = service.ErrIncorrectLoginOrPassword }, want: http.StatusUnauthorized},
+		{name: "Login throttled", method: http.MethodPost, path: "/api/user/login", contentType: "application/json", body: `{"login":"user","password":"pass"}`, anonymous: true,
+			setup: func(s *services) { s.user.err = &service.LoginThrottledError{RetryAfter: time.Minute} }, want: http.StatusTooManyRequests},
+		{name: "Login failure", method: http.MethodPost, path: "/api/user/login", contentType: "application/json", body: `{"login":"user","password":"pass"}`, anonymous: true,
+			setup: func(s *services) { s.user.err = errInternal }, want: http.StatusInternalServerError},
+
+		{name: "Refresh token", method: http.MethodPost, path: "/api/user/token/refresh", contentType: "application/json", body: `{"refresh_token":"refresh"}`, anonymous: true, want: http.StatusOK},
+		{name: "Refresh without token", method: http.MethodPost, path: "/api/user/token/refresh", contentType: "application/json", body: `{}`, anonymous: true, want: http.StatusBadRequest},
+		{name: "Refresh with invalid token", method: http.MethodPost, path: "/api/user/token/refresh", contentType: "application/json", body: `{"refresh_token":"refresh"}`, anonymous: true,
+			setup: func(s *services) { s.token.err = service.ErrInvalidRefreshToken }, want: http.StatusUnauthorized},
+		{name: "Refresh with reused token", method: http.MethodPost, path: "/api/user/token/refresh", contentType: "application/json", body: `{"refresh_token":"refresh"}`, anonymous: true,
+			setup: func(s *services) { s.token.err = service.ErrRefreshTokenReused }, want: http.StatusUnauthorized},
+
+		{name: "Logout", method: http.MethodPost, path: "/api/user/logout", want: http.StatusOK},
+		{name: "Logout with refresh token", method: http.MethodPost, path: "/api/user/logout", contentType: "application/json", body: `{"refresh_token":"refresh"}`, want: http.StatusOK},
+		{name: "Logout anonymously", method: http.MethodPost, path: "/api/user/logout", anonymous: true, want: http.StatusUnauthorized},
+
+		{name: "Change password", method: http.MethodPost, path: "/api/user/password", contentType: "application/json", body: `{"current_password":"pass","new_password":"new-pass"}`, want: http.StatusOK},
+		{name: "Change password to a weak one", method: http.MethodPost, path: "/api/user/password", contentType: "application/json", body: `{"current_password":"pass","new_password":"abc"}`, want: http.StatusBadRequest},
+		{name: "Change password with wrong current one", method: http.MethodPost, path: "/api/user/password", contentType: "application/json", body: `{"current_password":"wrong","new_password":"new-pass"}`,
+			setup: func(s *services) { s.password.err = service.ErrIncorrectPassword }, want: http.StatusForbidden},
+		{name: "Change password throttled", method: http.MethodPost, path: "/api/user/password", contentType: "application/json", body: `{"current_password":"wrong","new_password":"new-pass"}`,
+			setup: func(s *services) { s.password.err = &service.LoginThrottledError{RetryAfter: time.Minute} }, want: http.StatusTooManyRequests},
+		{name: "Change password anonymously", method: http.MethodPost, path: "/api/user/password", contentType: "application/json", body: `{"current_password":"pass","new_password":"new-pass"}`, anonymous: true, want: http.StatusUnauthorized},
+
+		{name: "Request password reset", method: http.MethodPost, path: "/api/user/password/reset", contentType: "application/json", body: `{"login":"user"}`, anonymous: true, want: http.StatusAccepted},
+		{name: "Request password reset without login", method: http.MethodPost, path: "/api/user/password/reset", contentType: "application/json", body: `{}`, anonymous: true, want: http.StatusBadRequest},
+		{name: "Request password reset too often", method: http.MethodPost, path: "/api/user/password/reset", contentType: "application/json", body: `{"login":"user"}`, anonymous: true,
+			setup: func(s *services) { s.password.err = &service.LoginThrottledError{RetryAfter: time.Minute} }, want: http.StatusTooManyRequests},
+		{name: "Confirm password reset", method: http.MethodPost, path: "/api/user/password/reset/confirm", contentType: "application/json", body: `{"token":"reset","new_password":"new-pass"}`, anonymous: true, want: http.StatusOK},
+		{name: "Confirm password reset with invalid token", method: http.MethodPost, path: "/api/user/password/reset/confirm", contentType: "application/json", body: `{"token":"reset","new_password":"new-pass"}`, anonymous: true,
+			setup: func(s *services) { s.password.err = service.ErrInvalidPasswordResetToken }, want: http.StatusBadRequest},
+		{name: "Confirm password reset failure", method: http.MethodPost, path: "/api/user/password/reset/confirm", contentType: "application/json", body: `{"token":"reset","new_password":"new-pass"}`, anonymous: true,
+			setup: func(s *services) { s.password.err = errInternal }, want: http.StatusInternalServerError},
+
+		{name: "Revoked access token", method: http.MethodGet, path: "/api/user/balance", setup: func(s *services) { s.token.revoked = true }, want: http.StatusUnauthorized},
+
+		{name: "Upload order", method: http.MethodPost, path: "/api/user/orders", contentType: "text/plain", body: "12345678903", want: http.StatusAccepted},
+		{name: "Upload own order again", method: http.MethodPost, path: "/api/user/orders", contentType: "text/plain", body: "12345678903",
+			setup: func(s *services) { s.order.err = repository.ErrOrderAlreadyExists }, want: http.StatusOK},
+		{name: "Upload order with wrong content type", method: http.MethodPost, path: "/api/user/orders", contentType: "application/json", body: `"12345678903"`, want: http.StatusBadRequest},
+		{name: "Upload order anonymously", method: http.MethodPost, path: "/api/user/orders", contentType: "text/plain", body: "12345678903", anonymous: true, want: http.StatusUnauthorized},
+		{name: "Upload order of another user", method: http.MethodPost, path: "/api/user/orders", contentType: "text/plain", body: "12345678903",
+			setup: func(s *services) { s.order.err = service.ErrOrderCreatedByAnotherUser }, want: http.StatusConflict},
+		{name: "Upload invalid order number", method: http.MethodPost, path: "/api/user/orders", contentType: "text/plain", body: "12345678904", want: http.StatusUnprocessableEntity},
+		{name: "Upload order failure", method: http.MethodPost, path: "/api/user/orders", contentType: "text/plain", body: "12345678903",
+			setup: func(s *services) { s.order.err = errInternal }, want: http.StatusInternalServerError},
+
+		{name: "Upload orders as JSON", method: http.MethodPost, path: "/api/user/orders/batch", contentType: "application/json", body: `["12345678903","12345678904"]`, want: http.StatusOK},
+		{name: "Upload orders as text", method: http.MethodPost, path: "/api/user/orders/batch", contentType: "text/plain", body: "12345678903\n\n9278923470\n", want: http.StatusOK},
+		{name: "Upload order with charset", method: http.MethodPost, path: "/api/user/orders", contentType: "text/plain; charset=utf-8", body: "12345678903", want: http.StatusAccepted},
+		{name: "Upload order with malformed content type", method: http.MethodPost, path: "/api/user/orders", contentType: "text/plain; charset", body: "12345678903", want: http.StatusBadRequest},
+		{name: "Upload orders as JSON with charset", method: http.MethodPost, path: "/api/user/orders/batch", contentType: "application/json; charset=utf-8", body: `["12345678903"]`, want: http.StatusOK},
+		{name: "Upload orders as text with charset", method: http.MethodPost, path: "/api/user/orders/batch", contentType: "text/plain; charset=utf-8", body: "12345678903", want: http.StatusOK},
+		{name: "Upload batch with malformed content type", method: http.MethodPost, path: "/api/user/orders/batch", contentType: "application/json; charset", body: `["12345678903"]`, want: http.StatusBadRequest},
+		{name: "Upload empty batch", method: http.MethodPost, path: "/api/user/orders/batch", contentType: "application/json", body: `[]`, want: http.StatusBadRequest},
+		{name: "Upload malformed batch", method: http.MethodPost, path: "/api/user/orders/batch", contentType: "application/json", body: `[12345678903]`, want: http.StatusBadRequest},
+		{name: "Upload batch anonymously", method: http.MethodPost, path: "/api/user/orders/batch", contentType: "text/plain", body: "12345678903", anonymous: true, want: http.StatusUnauthorized},
+		{name: "Upload batch failure", method: http.MethodPost, path: "/api/user/orders/batch", contentType: "text/plain", body: "12345678903",
+			setup: func(s *services) { s.order.err = errInternal }, want: http.StatusInternalServerError},
+
+		{name: "List orders", method: http.MethodGet, path: "/api/user/orders", setup: func(s *services) { s.order.orders = orders }, want: http.StatusOK},
+		{name: "List orders by status", method: http.MethodGet, path: "/api/user/orders?status=processed,NEW", setup: func(s *services) { s.order.orders = orders }, want: http.StatusOK},
+		{name: "List orders by internal status", method: http.MethodGet, path: "/api/user/orders?status=STALE", want: http.StatusBadRequest},
+		{name: "List orders by unknown status", method: http.MethodGet, path: "/api/user/orders?status=REGISTERED", want: http.StatusBadRequest},
+		{name: "List no orders", method: http.MethodGet, path: "/api/user/orders", setup: func(s *services) { s.order.err = repository.ErrNoOrdersFound }, want: http.StatusNoContent},
+		{name: "List orders anonymously", method: http.MethodGet, path: "/api/user/orders", anonymous: true, want: http.StatusUnauthorized},
+		{name: "List orders failure", method: http.MethodGet, path: "/api/user/orders", setup: func(s *services) { s.order.err = errInternal }, want: http.StatusInternalServerError},
+
+		{name: "Get order", method: http.MethodGet, path: "/api/user/orders/9278923470", setup: func(s *services) { s.order.orders = orders }, want: http.StatusOK},
+		{name: "Get unknown order", method: http.MethodGet, path: "/api/user/orders/9278923470", setup: func(s *services) { s.order.err = repository.ErrOrderNotFound }, want: http.StatusNotFound},
+		{name: "Get invalid order number", method: http.MethodGet, path: "/api/user/orders/9278923471", want: http.StatusNotFound},
+
+		{name: "Balance", method: http.MethodGet, path: "/api/user/balance", want: http.StatusOK},
+		{name: "Balance anonymously", method: http.MethodGet, path: "/api/user/balance", anonymous: true, want: http.StatusUnauthorized},
+		{name: "Balance failure", method: http.MethodGet, path: "/api/user/balance", setup: func(s *services) { s.balance.err = errInternal }, want: http.StatusInternalServerError},
+
+		{name: "Withdraw", method: http.MethodPost, path: "/api/user/balance/withdraw", contentType: "application/json", body: `{"order":"2377225624","sum":751}`, want: http.StatusOK},
+		{name: "Withdraw anonymously", method: http.MethodPost, path: "/api/user/balance/withdraw", contentType: "application/json", body: `{"order":"2377225624","sum":751}`, anonymous: true, want: http.StatusUnauthorized},
+		{name: "Withdraw more than balance", method: http.MethodPost, path: "/api/user/balance/withdraw", contentType: "application/json", body: `{"order":"2377225624","sum":751}`,
+			setup: func(s *services) { s.withdrawal.err = service.ErrNotEnoughBalance }, want: http.StatusPaymentRequired},
+		{name: "Withdraw to invalid order number", method: http.MethodPost, path: "/api/user/balance/withdraw", contentType: "application/json", body: `{"order":"2377225625","sum":751}`, want: http.StatusUnprocessableEntity},
+		{name: "Withdraw zero", method: http.MethodPost, path: "/api/user/balance/withdraw", contentType: "application/json", body: `{"order":"2377225624","sum":0}`, want: http.StatusBadRequest},
+		{name: "Withdraw negative sum", method: http.MethodPost, path: "/api/user/balance/withdraw", contentType: "application/json", body: `{"order":"2377225624","sum":-1}`, want: http.StatusBadRequest},
+		{name: "Withdraw failure", method: http.MethodPost, path: "/api/user/balance/withdraw", contentType: "application/json", body: `{"order":"2377225624","sum":751}`,
+			setup: func(s *services) { s.withdrawal.err = errInternal }, want: http.StatusInternalServerError},
+
+		{name: "List withdrawals", method: http.MethodGet, path: "/api/user/withdrawals", setup: func(s *services) { s.withdrawal.withdrawals = withdrawals }, want: http.StatusOK},
+		{name: "List no withdrawals", method: http.MethodGet, path: "/api/user/withdrawals", setup: func(s *services) { s.withdrawal.err = repository.ErrNoWithdrawalsFound }, want: http.StatusNoContent},
+		{name: "List withdrawals anonymously", method: http.MethodGet, path: "/api/user/withdrawals", anonymous: true, want: http.StatusUnauthorized},
+		{name: "List withdrawals failure", method: http.MethodGet, path: "/api/user/withdrawals", setup: func(s *services) { s.withdrawal.err = errInternal }, want: http.StatusInternalServerError},
+
+		{name: "Accrual callback", method: http.MethodPost, path: "/internal/accrual/callback", body: callbackBody,
+			headers: callbackHeaders(callbackBody, time.Now()), anonymous: true, want: http.StatusOK},
+		{name: "Accrual callback with bad signature", method: http.MethodPost, path: "/internal/accrual/callback", body: callbackBody,
+			headers: callbackHeaders(`{"order":"9278923470","status":"INVALID"}`, time.Now()), anonymous: true, want: http.StatusUnauthorized},
+		{name: "Accrual callback without signature", method: http.MethodPost, path: "/internal/accrual/callback", body: callbackBody, anonymous: true, want: http.StatusUnauthorized},
+		{name: "Accrual callback with stale timestamp", method: http.MethodPost, path: "/internal/accrual/callback", body: callbackBody,
+			headers: callbackHeaders(callbackBody, time.Now().Add(-time.Hour)), anonymous: true, want: http.StatusUnauthorized},
+		{name: "Accrual callback with malformed body", method: http.MethodPost, path: "/internal/accrual/callback", body: `{"order":`,
+			headers: callbackHeaders(`{"order":`, time.Now()), anonymous: true, want: http.StatusBadRequest},
+		{name: "Accrual callback with unknown status", method: http.MethodPost, path: "/internal/accrual/callback", body: `{"order":"9278923470","status":"STALE"}`,
+			headers: callbackHeaders(`{"order":"9278923470","status":"STALE"}`, time.Now()), anonymous: true, want: http.StatusBadRequest},
+		{name: "Accrual callback for unknown order", method: http.MethodPost, path: "/internal/accrual/callback", body: callbackBody,
+			headers: callbackHeaders(callbackBody, time.Now()), anonymous: true, setup: func(s *services) { s.order.err = repository.ErrOrderNotFound }, want: http.StatusNotFound},
+		{name: "Accrual callback with illegal transition", method: http.MethodPost, path: "/internal/accrual/callback", body: callbackBody,
+			headers: callbackHeaders(callbackBody, time.Now()), anonymous: true, setup: func(s *services) { s.order.err = repository.ErrIllegalStatusTransition }, want: http.StatusConflict},
+		{name: "Accrual callback repeated", method: http.MethodPost, path: "/internal/accrual/callback", body: callbackBody,
+			headers: callbackHeaders(callbackBody, time.Now()), anonymous: true, setup: func(s *services) { s.order.err = repository.ErrOrderStatusUnchanged }, want: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var s services
+			if test.setup != nil {
+				test.setup(&s)
+			}
+
+			request := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+			if test.contentType != "" {
+				request.Header.Set("Content-Type", test.contentType)
+			}
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+			if !test.anonymous {
+				request.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			recorder := httptest.NewRecorder()
+			newTestRouter(&s, jwtService).ServeHTTP(recorder, request)
+
+			if recorder.Code != test.want {
+				t.Errorf("%s %s status = %d, want %d; body: %s", test.method, test.path, recorder.Code, test.want, recorder.Body.String())
+			}
+			if test.want == http.StatusNoContent && recorder.Body.Len() != 0 {
+				t.Errorf("%s %s body = %q, want empty", test.method, test.path, recorder.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/zavtra-na-rabotu/gophermart/internal/dto"
+	"github.com/zavtra-na-rabotu/gophermart/internal/integration"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+const (
+	healthOK          = "ok"
+	healthDegraded    = "degraded"
+	healthUnavailable = "unavailable"
+)
+
+type HealthHandler struct {
+	db             *sql.DB
+	accrualBreaker *integration.CircuitBreaker
+}
+
+func NewHealthHandler(db *sql.DB, accrualBreaker *integration.CircuitBreaker) *HealthHandler {
+	return &HealthHandler{db: db, accrualBreaker: accrualBreaker}
+}
+
+// GetHealth answers 503 only when the database is unreachable. An open accrual circuit
+// degrades the service but does not make it unhealthy: users can still log in and upload orders.
+func (h *HealthHandler) GetHealth() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := dto.HealthResponse{
+			Status:        healthOK,
+			Database:      healthOK,
+			AccrualSystem: string(h.accrualBreaker.State()),
+		}
+		statusCode := http.StatusOK
+
+		if h.accrualBreaker.State() != integration.CircuitClosed {
+			response.Status = healthDegraded
+		}
+
+		if err := h.db.PingContext(r.Context()); err != nil {
+			zap.L().Error("Database health check failed", zap.Error(err))
+			response.Status = healthUnavailable
+			response.Database = healthUnavailable
+			statusCode = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			zap.L().Error("Failed to write response", zap.Error(err))
+			return
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/zavtra-na-rabotu/gophermart/internal/dto"
+	"github.com/zavtra-na-rabotu/gophermart/internal/security"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// keySet is the part of security.JwtService the handler works with.
+type keySet interface {
+	JWKS() []security.JWK
+}
+
+type JWKSHandler struct {
+	keySet keySet
+}
+
+func NewJWKSHandler(keySet keySet) *JWKSHandler {
+	return &JWKSHandler{keySet: keySet}
+}
+
+// GetJWKS publishes the public keys access tokens can be verified with, so other services do not need the secret.
+func (h *JWKSHandler) GetJWKS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		response := dto.JWKSResponse{Keys: h.keySet.JWKS()}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			zap.L().Error("Failed to write response", zap.Error(err))
+		}
+	}
+}
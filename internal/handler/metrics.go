@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/zavtra-na-rabotu/gophermart/internal/integration"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+type MetricsHandler struct {
+	accrualBreaker *integration.CircuitBreaker
+}
+
+func NewMetricsHandler(accrualBreaker *integration.CircuitBreaker) *MetricsHandler {
+	return &MetricsHandler{accrualBreaker: accrualBreaker}
+}
+
+// GetMetrics renders metrics in the Prometheus text exposition format.
+func (h *MetricsHandler) GetMetrics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := h.accrualBreaker.Stats()
+
+		var b strings.Builder
+		b.WriteString("# HELP accrual_circuit_breaker_state Current state of the accrual system circuit breaker.\n")
+		b.WriteString("# TYPE accrual_circuit_breaker_state gauge\n")
+		for _, state := range []integration.CircuitState{integration.CircuitClosed, integration.CircuitOpen, integration.CircuitHalfOpen} {
+			value := 0
+			if stats.State == state {
+				value = 1
+			}
+			fmt.Fprintf(&b, "accrual_circuit_breaker_state{state=%q} %d\n", state, value)
+		}
+		b.WriteString("# HELP accrual_circuit_breaker_consecutive_failures Consecutive failed requests to the accrual system.\n")
+		b.WriteString("# TYPE accrual_circuit_breaker_consecutive_failures gauge\n")
+		fmt.Fprintf(&b, "accrual_circuit_breaker_consecutive_failures %d\n", stats.ConsecutiveFailures)
+		b.WriteString("# HELP accrual_circuit_breaker_opens_total Times the accrual circuit breaker has opened.\n")
+		b.WriteString("# TYPE accrual_circuit_breaker_opens_total counter\n")
+		fmt.Fprintf(&b, "accrual_circuit_breaker_opens_total %d\n", stats.Opens)
+		b.WriteString("# HELP accrual_circuit_breaker_rejected_total Requests rejected while the accrual circuit breaker was open.\n")
+		b.WriteString("# TYPE accrual_circuit_breaker_rejected_total counter\n")
+		fmt.Fprintf(&b, "accrual_circuit_breaker_rejected_total %d\n", stats.Rejected)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.WriteHeader(http.StatusOK)
+
+		if _, err := w.Write([]byte(b.String())); err != nil {
+			zap.L().Error("Failed to write response", zap.Error(err))
+		}
+	}
+}
@@ -1,38 +1,58 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
 	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
 	"github.com/zavtra-na-rabotu/gophermart/internal/dto"
 	"github.com/zavtra-na-rabotu/gophermart/internal/middleware"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
 	"github.com/zavtra-na-rabotu/gophermart/internal/service"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/cursor"
 	"github.com/zavtra-na-rabotu/gophermart/internal/utils/luhn"
 	"go.uber.org/zap"
 	"io"
+	"mime"
 	"net/http"
+	"strings"
 	"time"
 )
 
+// orderService is the part of service.OrderService the handler works with.
+type orderService interface {
+	CreateOrder(ctx context.Context, orderNumber string, userID int) error
+	CreateOrders(ctx context.Context, orderNumbers []string, userID int) ([]model.OrderUpload, error)
+	GetOrders(ctx context.Context, userID int, query model.OrderQuery) ([]model.Order, *cursor.Cursor, error)
+	GetOrder(ctx context.Context, orderNumber string, userID int) (*model.Order, []model.OrderStatusChange, error)
+}
+
+const (
+	maxBatchOrders   = 1000
+	maxBatchBodySize = 1 << 20
+)
+
 type OrderHandler struct {
-	orderService *service.OrderService
+	orderService orderService
+	pageLimits   PageLimits
 }
 
-func NewOrderHandler(orderService *service.OrderService) *OrderHandler {
-	return &OrderHandler{orderService: orderService}
+func NewOrderHandler(orderService orderService, pageLimits PageLimits) *OrderHandler {
+	return &OrderHandler{orderService: orderService, pageLimits: pageLimits}
 }
 
 func (h *OrderHandler) CreateOrder() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		contentType := r.Header.Get("Content-Type")
-		if contentType != "text/plain" {
+		if mediaType, err := requestMediaType(r); err != nil || mediaType != "text/plain" {
 			http.Error(w, "Invalid request content type", http.StatusBadRequest)
 			return
 		}
 
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, "Incorrect input json", http.StatusInternalServerError)
+			http.Error(w, "Failed to read body", http.StatusBadRequest)
 			return
 		}
 
@@ -43,7 +63,7 @@ func (h *OrderHandler) CreateOrder() http.HandlerFunc {
 		}
 
 		userID := r.Context().Value(middleware.UserIDKey).(int)
-		err = h.orderService.CreateOrder(orderNumber, userID)
+		err = h.orderService.CreateOrder(r.Context(), orderNumber, userID)
 		if err != nil {
 			if errors.Is(err, repository.ErrOrderAlreadyExists) {
 				http.Error(w, "Order already exists", http.StatusOK)
@@ -62,14 +82,92 @@ func (h *OrderHandler) CreateOrder() http.HandlerFunc {
 	}
 }
 
+// CreateOrders uploads many order numbers at once, given as a JSON array of strings or as text with one number
+// per line. Every number gets its own result, so a batch is accepted even if some of its numbers are not.
+func (h *OrderHandler) CreateOrders() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBodySize))
+		if err != nil {
+			http.Error(w, "Failed to read body", http.StatusBadRequest)
+			return
+		}
+
+		mediaType, err := requestMediaType(r)
+		if err != nil {
+			http.Error(w, "Invalid request content type", http.StatusBadRequest)
+			return
+		}
+
+		var orderNumbers []string
+		switch mediaType {
+		case "application/json":
+			if err := json.Unmarshal(body, &orderNumbers); err != nil {
+				zap.L().Error("Failed to parse body", zap.Error(err))
+				http.Error(w, "Failed to parse body", http.StatusBadRequest)
+				return
+			}
+		case "text/plain":
+			for _, line := range strings.Split(string(body), "\n") {
+				if orderNumber := strings.TrimSpace(line); orderNumber != "" {
+					orderNumbers = append(orderNumbers, orderNumber)
+				}
+			}
+		default:
+			http.Error(w, "Invalid request content type", http.StatusBadRequest)
+			return
+		}
+
+		if len(orderNumbers) == 0 || len(orderNumbers) > maxBatchOrders {
+			http.Error(w, fmt.Sprintf("Batch must contain from 1 to %d order numbers", maxBatchOrders), http.StatusBadRequest)
+			return
+		}
+
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		uploads, err := h.orderService.CreateOrders(r.Context(), orderNumbers, userID)
+		if err != nil {
+			zap.L().Error("Failed to create orders", zap.Error(err))
+			http.Error(w, "Failed to create orders", http.StatusInternalServerError)
+			return
+		}
+
+		response := make([]dto.CreateOrdersResponse, len(uploads))
+		for i, upload := range uploads {
+			response[i] = dto.CreateOrdersResponse{Number: upload.Number, Result: upload.Result}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			zap.L().Error("Failed to write response", zap.Error(err))
+			http.Error(w, "Failed to write response", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// GetOrders returns a page of the current user's orders, optionally filtered by status and upload time.
+// The next page, if any, is announced in the Link and X-Next-Cursor headers.
 func (h *OrderHandler) GetOrders() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Context().Value(middleware.UserIDKey).(int)
 
-		orders, err := h.orderService.GetOrders(userID)
+		page, err := parsePageQuery(r.URL.Query(), h.pageLimits)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		statuses, err := parseOrderStatuses(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		orders, next, err := h.orderService.GetOrders(r.Context(), userID, model.OrderQuery{PageQuery: page, Statuses: statuses})
 		if err != nil {
 			if errors.Is(err, repository.ErrNoOrdersFound) {
-				http.Error(w, "No orders found", http.StatusNoContent)
+				w.WriteHeader(http.StatusNoContent)
 				return
 			}
 			zap.L().Error("Failed to get orders", zap.Error(err))
@@ -81,12 +179,63 @@ func (h *OrderHandler) GetOrders() http.HandlerFunc {
 		for i, order := range orders {
 			response[i] = dto.GetOrdersResponse{
 				Number:     order.Number,
-				Status:     order.Status,
+				Status:     order.Status.APIStatus(),
 				Accrual:    order.Accrual,
 				UploadedAt: order.UploadedAt.Format(time.RFC3339),
 			}
 		}
 
+		setNextPageHeaders(w, r, next)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			zap.L().Error("Failed to write response", zap.Error(err))
+			http.Error(w, "Failed to write response", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// GetOrder returns an order of the current user with the history of its statuses.
+// Unknown orders and orders of other users are both answered with 404.
+func (h *OrderHandler) GetOrder() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+		orderNumber := chi.URLParam(r, "number")
+
+		// A number that fails the check cannot belong to any order, so it is not found rather than a bad request
+		if !luhn.Valid(orderNumber) {
+			http.Error(w, "Order not found", http.StatusNotFound)
+			return
+		}
+
+		order, history, err := h.orderService.GetOrder(r.Context(), orderNumber, userID)
+		if err != nil {
+			if errors.Is(err, repository.ErrOrderNotFound) {
+				http.Error(w, "Order not found", http.StatusNotFound)
+				return
+			}
+			zap.L().Error("Failed to get order", zap.Error(err))
+			http.Error(w, "Failed to get order", http.StatusInternalServerError)
+			return
+		}
+
+		response := dto.GetOrderResponse{
+			Number:     order.Number,
+			Status:     order.Status.APIStatus(),
+			Accrual:    order.Accrual,
+			UploadedAt: order.UploadedAt.Format(time.RFC3339),
+			History:    make([]dto.OrderStatusResponse, len(history)),
+		}
+		for i, change := range history {
+			response.History[i] = dto.OrderStatusResponse{
+				Status:    change.Status.APIStatus(),
+				Accrual:   change.Accrual,
+				ChangedAt: change.ChangedAt.Format(time.RFC3339),
+			}
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 
@@ -97,3 +246,10 @@ func (h *OrderHandler) GetOrders() http.HandlerFunc {
 		}
 	}
 }
+
+// requestMediaType returns the media type of the request body. Parameters such as charset=utf-8 are allowed
+// and left out, so only the media type is compared.
+func requestMediaType(r *http.Request) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType, err
+}
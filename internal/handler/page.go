@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/cursor"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const nextCursorHeader = "X-Next-Cursor"
+
+var errInvalidPageQuery = errors.New("invalid page query")
+
+// PageLimits bound the number of rows list endpoints return at once.
+type PageLimits struct {
+	Default int
+	Max     int
+}
+
+// parsePageQuery reads limit, cursor, sort, from and to query parameters. A limit above the maximum is lowered to it.
+// Without limit and cursor the whole list is returned, as the specification requires; a cursor without limit
+// continues with pages of the default size. Lists are sorted from newest to oldest unless sort=asc is given.
+func parsePageQuery(query url.Values, limits PageLimits) (model.PageQuery, error) {
+	page := model.PageQuery{Sort: model.SortDescending}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return page, fmt.Errorf("%w: limit must be a positive number", errInvalidPageQuery)
+		}
+		page.Limit = max(min(limit, limits.Max), 1)
+	} else if query.Get("cursor") != "" {
+		page.Limit = max(min(limits.Default, limits.Max), 1)
+	}
+
+	if value := query.Get("cursor"); value != "" {
+		after, err := cursor.Decode(value)
+		if err != nil {
+			return page, fmt.Errorf("%w: %w", errInvalidPageQuery, err)
+		}
+		page.After = &after
+	}
+
+	switch sort := model.SortDirection(strings.ToLower(query.Get("sort"))); sort {
+	case "":
+	case model.SortAscending, model.SortDescending:
+		page.Sort = sort
+	default:
+		return page, fmt.Errorf("%w: sort must be asc or desc", errInvalidPageQuery)
+	}
+
+	var err error
+	page.From, err = parseTimeParameter(query, "from")
+	if err != nil {
+		return page, err
+	}
+
+	page.To, err = parseTimeParameter(query, "to")
+	if err != nil {
+		return page, err
+	}
+
+	return page, nil
+}
+
+// parseOrderStatuses reads status query parameters, which may repeat or hold comma separated statuses.
+// Only the statuses of the API are accepted: NEW, PROCESSING, INVALID and PROCESSED.
+// INVALID also selects stale orders, which the API shows as INVALID.
+func parseOrderStatuses(query url.Values) ([]model.OrderStatus, error) {
+	var statuses []model.OrderStatus
+	for _, value := range query["status"] {
+		for _, name := range strings.Split(value, ",") {
+			status := model.OrderStatus(strings.ToUpper(strings.TrimSpace(name)))
+			if !status.Valid() {
+				return nil, fmt.Errorf("%w: unknown status %q", errInvalidPageQuery, name)
+			}
+			statuses = append(statuses, status)
+			if status == model.Invalid {
+				statuses = append(statuses, model.Stale)
+			}
+		}
+	}
+
+	return statuses, nil
+}
+
+func parseTimeParameter(query url.Values, name string) (time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s must be an RFC 3339 time", errInvalidPageQuery, name)
+	}
+
+	return parsed, nil
+}
+
+// setNextPageHeaders points the client at the next page with a Link header and the bare cursor in X-Next-Cursor.
+// Nothing is set on the last page.
+func setNextPageHeaders(w http.ResponseWriter, r *http.Request, next *cursor.Cursor) {
+	if next == nil {
+		return
+	}
+
+	token := next.Encode()
+
+	query := r.URL.Query()
+	query.Set("cursor", token)
+
+	w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, query.Encode()))
+	w.Header().Set(nextCursorHeader, token)
+}
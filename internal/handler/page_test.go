@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/cursor"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParsePageQueryLimit(t *testing.T) {
+	limits := PageLimits{Default: 10, Max: 100}
+	next := cursor.New(time.Date(2020, 12, 10, 15, 15, 45, 0, time.UTC), 1).Encode()
+
+	tests := []struct {
+		name  string
+		query url.Values
+		want  int
+	}{
+		{name: "Whole list without limit and cursor", query: url.Values{}, want: 0},
+		{name: "Whole list with filters only", query: url.Values{"sort": {"asc"}, "from": {"2020-12-10T00:00:00Z"}}, want: 0},
+		{name: "Default page size with cursor", query: url.Values{"cursor": {next}}, want: 10},
+		{name: "Given limit", query: url.Values{"limit": {"5"}}, want: 5},
+		{name: "Limit above maximum", query: url.Values{"limit": {"1000"}}, want: 100},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := parsePageQuery(test.query, limits)
+			if err != nil {
+				t.Fatalf("parsePageQuery() error = %v", err)
+			}
+			if page.Limit != test.want {
+				t.Errorf("parsePageQuery() limit = %d, want %d", page.Limit, test.want)
+			}
+		})
+	}
+}
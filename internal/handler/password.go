@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/zavtra-na-rabotu/gophermart/internal/dto"
+	"github.com/zavtra-na-rabotu/gophermart/internal/middleware"
+	"github.com/zavtra-na-rabotu/gophermart/internal/service"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/stringutils"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+// passwordService is the part of service.PasswordService the handler works with.
+type passwordService interface {
+	ChangePassword(ctx context.Context, userID int, currentPassword string, newPassword string) error
+	RequestPasswordReset(ctx context.Context, login string, clientIP string) error
+	ConfirmPasswordReset(ctx context.Context, token string, newPassword string) error
+}
+
+// passwordValidator is the part of validation.UserValidator the handler works with.
+type passwordValidator interface {
+	ValidatePassword(password string) error
+}
+
+type PasswordHandler struct {
+	passwordService passwordService
+	validator       passwordValidator
+}
+
+func NewPasswordHandler(passwordService passwordService, validator passwordValidator) *PasswordHandler {
+	return &PasswordHandler{passwordService: passwordService, validator: validator}
+}
+
+// ChangePassword sets a new password of the authenticated user. All tokens issued before, including
+// the one of the request, stop working, so the user has to log in again.
+func (h *PasswordHandler) ChangePassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Context().Value(middleware.UserIDKey).(int)
+
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+			http.Error(w, "Invalid request content type", http.StatusBadRequest)
+			return
+		}
+
+		var request dto.ChangePasswordRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || stringutils.IsEmpty(request.CurrentPassword) {
+			http.Error(w, "Failed to parse body", http.StatusBadRequest)
+			return
+		}
+
+		if err := h.validator.ValidatePassword(request.NewPassword); err != nil {
+			writeValidationErrors(w, err)
+			return
+		}
+
+		err := h.passwordService.ChangePassword(r.Context(), userID, request.CurrentPassword, request.NewPassword)
+		if err != nil {
+			var throttled *service.LoginThrottledError
+			if errors.As(err, &throttled) {
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(throttled.RetryAfter)))
+				http.Error(w, "Too many attempts", http.StatusTooManyRequests)
+				return
+			}
+			if errors.Is(err, service.ErrIncorrectPassword) {
+				http.Error(w, "Incorrect current password", http.StatusForbidden)
+				return
+			}
+			zap.L().Error("Failed to change password", zap.Error(err))
+			http.Error(w, "Failed to change password", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// RequestPasswordReset answers 202 whether the login exists or not. Requests count towards the login limits.
+func (h *PasswordHandler) RequestPasswordReset() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+			http.Error(w, "Invalid request content type", http.StatusBadRequest)
+			return
+		}
+
+		var request dto.PasswordResetRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || stringutils.IsEmpty(request.Login) {
+			http.Error(w, "Failed to parse body", http.StatusBadRequest)
+			return
+		}
+
+		err := h.passwordService.RequestPasswordReset(r.Context(), request.Login, clientIP(r))
+		if err != nil {
+			var throttled *service.LoginThrottledError
+			if errors.As(err, &throttled) {
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(throttled.RetryAfter)))
+				http.Error(w, "Too many attempts", http.StatusTooManyRequests)
+				return
+			}
+			zap.L().Error("Failed to request password reset", zap.Error(err))
+			http.Error(w, "Failed to request password reset", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// ConfirmPasswordReset sets a new password with a reset token.
+func (h *PasswordHandler) ConfirmPasswordReset() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+			http.Error(w, "Invalid request content type", http.StatusBadRequest)
+			return
+		}
+
+		var request dto.PasswordResetConfirmRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || stringutils.IsEmpty(request.Token) {
+			http.Error(w, "Failed to parse body", http.StatusBadRequest)
+			return
+		}
+
+		if err := h.validator.ValidatePassword(request.NewPassword); err != nil {
+			writeValidationErrors(w, err)
+			return
+		}
+
+		err := h.passwordService.ConfirmPasswordReset(r.Context(), request.Token, request.NewPassword)
+		if err != nil {
+			if errors.Is(err, service.ErrInvalidPasswordResetToken) {
+				http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+				return
+			}
+			zap.L().Error("Failed to reset password", zap.Error(err))
+			http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/zavtra-na-rabotu/gophermart/internal/dto"
+	"github.com/zavtra-na-rabotu/gophermart/internal/middleware"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"github.com/zavtra-na-rabotu/gophermart/internal/security"
+	"github.com/zavtra-na-rabotu/gophermart/internal/service"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/stringutils"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"time"
+)
+
+// tokenService is the part of service.TokenService the handler works with.
+type tokenService interface {
+	Refresh(ctx context.Context, refreshToken string) (*model.TokenPair, error)
+	Logout(ctx context.Context, claims *security.CustomClaims, refreshToken string) error
+}
+
+type TokenHandler struct {
+	tokenService tokenService
+}
+
+func NewTokenHandler(tokenService tokenService) *TokenHandler {
+	return &TokenHandler{tokenService: tokenService}
+}
+
+// RefreshToken exchanges a refresh token for a new token pair. The refresh token can not be used again.
+func (h *TokenHandler) RefreshToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+			http.Error(w, "Invalid request content type", http.StatusBadRequest)
+			return
+		}
+
+		var request dto.RefreshTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || stringutils.IsEmpty(request.RefreshToken) {
+			http.Error(w, "Failed to parse body", http.StatusBadRequest)
+			return
+		}
+
+		pair, err := h.tokenService.Refresh(r.Context(), request.RefreshToken)
+		if err != nil {
+			if errors.Is(err, service.ErrInvalidRefreshToken) || errors.Is(err, service.ErrRefreshTokenReused) {
+				http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+				return
+			}
+			zap.L().Error("Failed to refresh token", zap.Error(err))
+			http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
+			return
+		}
+
+		writeTokenPair(w, pair)
+	}
+}
+
+// Logout revokes the access token of the request and the refresh token from the body, if there is one.
+func (h *TokenHandler) Logout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims := r.Context().Value(middleware.ClaimsKey).(*security.CustomClaims)
+
+		var request dto.LogoutRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Failed to parse body", http.StatusBadRequest)
+			return
+		}
+
+		err := h.tokenService.Logout(r.Context(), claims, request.RefreshToken)
+		if err != nil {
+			zap.L().Error("Failed to logout", zap.Error(err))
+			http.Error(w, "Failed to logout", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// writeTokenPair answers with the access token in the Authorization header, as the specification requires,
+// and with the whole pair in the body.
+func writeTokenPair(w http.ResponseWriter, pair *model.TokenPair) {
+	response := dto.TokenResponse{
+		AccessToken:  pair.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(time.Until(pair.AccessTokenExpiresAt).Seconds()),
+		RefreshToken: pair.RefreshToken,
+	}
+
+	w.Header().Set("Authorization", "Bearer "+pair.AccessToken)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		zap.L().Error("Failed to write response", zap.Error(err))
+	}
+}
@@ -1,21 +1,41 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
 	"github.com/zavtra-na-rabotu/gophermart/internal/dto"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
 	"github.com/zavtra-na-rabotu/gophermart/internal/service"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/stringutils"
+	"github.com/zavtra-na-rabotu/gophermart/internal/validation"
 	"go.uber.org/zap"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+// userService is the part of service.UserService the handler works with.
+type userService interface {
+	RegisterUser(ctx context.Context, request *dto.RegisterUserRequest) (*model.TokenPair, error)
+	LoginUser(ctx context.Context, request *dto.LoginUserRequest, clientIP string) (*model.TokenPair, error)
+}
+
+// registrationValidator is the part of validation.UserValidator the handler works with.
+type registrationValidator interface {
+	ValidateRegistration(login string, password string) error
+}
+
 type UserHandler struct {
-	userService *service.UserService
+	userService userService
+	validator   registrationValidator
 }
 
-func NewUserHandler(userService *service.UserService) *UserHandler {
-	return &UserHandler{userService: userService}
+func NewUserHandler(userService userService, validator registrationValidator) *UserHandler {
+	return &UserHandler{userService: userService, validator: validator}
 }
 
 func (h *UserHandler) RegisterUser() http.HandlerFunc {
@@ -32,20 +52,23 @@ func (h *UserHandler) RegisterUser() http.HandlerFunc {
 			return
 		}
 
-		//var user = model.User{Login: request.Login, Password: request.Password}
-		token, err := h.userService.RegisterUser(&request)
+		if err := h.validator.ValidateRegistration(request.Login, request.Password); err != nil {
+			writeValidationErrors(w, err)
+			return
+		}
+
+		pair, err := h.userService.RegisterUser(r.Context(), &request)
 		if err != nil {
 			if errors.Is(err, repository.ErrUserAlreadyExists) {
 				http.Error(w, "User already exists", http.StatusConflict)
 				return
 			}
 			zap.L().Error("Failed to register user", zap.Error(err))
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, "Failed to register user", http.StatusInternalServerError)
 			return
 		}
 
-		w.Header().Set("Authorization", "Bearer "+token)
-		w.WriteHeader(http.StatusOK)
+		writeTokenPair(w, pair)
 	}
 }
 
@@ -63,14 +86,74 @@ func (h *UserHandler) LoginUser() http.HandlerFunc {
 			return
 		}
 
-		token, err := h.userService.LoginUser(&request)
+		if stringutils.IsEmpty(request.Login) || stringutils.IsEmpty(request.Password) {
+			http.Error(w, "Login and password are required", http.StatusBadRequest)
+			return
+		}
+
+		pair, err := h.userService.LoginUser(r.Context(), &request, clientIP(r))
 		if err != nil {
+			var throttled *service.LoginThrottledError
+			if errors.As(err, &throttled) {
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(throttled.RetryAfter)))
+				http.Error(w, "Too many login attempts", http.StatusTooManyRequests)
+				return
+			}
+			if errors.Is(err, service.ErrIncorrectLoginOrPassword) {
+				http.Error(w, "Incorrect login or password", http.StatusUnauthorized)
+				return
+			}
 			zap.L().Error("Failed to login user", zap.Error(err))
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, "Failed to login user", http.StatusInternalServerError)
 			return
 		}
 
-		w.Header().Set("Authorization", "Bearer "+token)
-		w.WriteHeader(http.StatusOK)
+		writeTokenPair(w, pair)
 	}
 }
+
+// writeValidationErrors answers 400 with every field error, so the client can show them next to the fields.
+func writeValidationErrors(w http.ResponseWriter, err error) {
+	var errs validation.Errors
+	if !errors.As(err, &errs) {
+		zap.L().Error("Failed to validate request", zap.Error(err))
+		http.Error(w, "Failed to validate request", http.StatusInternalServerError)
+		return
+	}
+
+	response := dto.ValidationErrorResponse{
+		Error:  "Invalid request",
+		Errors: make([]dto.FieldErrorResponse, len(errs)),
+	}
+	for i, fieldError := range errs {
+		response.Errors[i] = dto.FieldErrorResponse{Field: fieldError.Field, Message: fieldError.Message}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		zap.L().Error("Failed to write response", zap.Error(err))
+	}
+}
+
+// clientIP is the address the request came from. Behind a proxy it is only the client address
+// if proxy headers are trusted, see the RealIP middleware.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// retryAfterSeconds rounds up, so a client that waits as told is not turned away again.
+func retryAfterSeconds(retryAfter time.Duration) int {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+
+	return seconds
+}
@@ -1,38 +1,52 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
 	"github.com/zavtra-na-rabotu/gophermart/internal/dto"
 	"github.com/zavtra-na-rabotu/gophermart/internal/middleware"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
 	"github.com/zavtra-na-rabotu/gophermart/internal/service"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/cursor"
 	"github.com/zavtra-na-rabotu/gophermart/internal/utils/luhn"
 	"go.uber.org/zap"
 	"net/http"
 	"time"
 )
 
-var (
-	ErrNotEnoughBalance = errors.New("not enough balance")
-)
+// withdrawalService is the part of service.WithdrawalService the handler works with.
+type withdrawalService interface {
+	GetWithdrawals(ctx context.Context, userID int, page model.PageQuery) ([]model.Withdrawal, *cursor.Cursor, error)
+	CreateWithdrawal(ctx context.Context, userID int, orderNumber string, sum model.Amount) error
+}
 
 type WithdrawalHandler struct {
-	withdrawalService *service.WithdrawalService
+	withdrawalService withdrawalService
+	pageLimits        PageLimits
 }
 
-func NewWithdrawalHandler(withdrawalService *service.WithdrawalService) *WithdrawalHandler {
-	return &WithdrawalHandler{withdrawalService: withdrawalService}
+func NewWithdrawalHandler(withdrawalService withdrawalService, pageLimits PageLimits) *WithdrawalHandler {
+	return &WithdrawalHandler{withdrawalService: withdrawalService, pageLimits: pageLimits}
 }
 
+// GetWithdrawals returns a page of the current user's withdrawals, optionally filtered by processing time.
+// The next page, if any, is announced in the Link and X-Next-Cursor headers.
 func (h *WithdrawalHandler) GetWithdrawals() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID := r.Context().Value(middleware.UserIDKey).(int)
 
-		withdrawals, err := h.withdrawalService.GetWithdrawals(userID)
+		page, err := parsePageQuery(r.URL.Query(), h.pageLimits)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		withdrawals, next, err := h.withdrawalService.GetWithdrawals(r.Context(), userID, page)
 		if err != nil {
 			if errors.Is(err, repository.ErrNoWithdrawalsFound) {
-				http.Error(w, "No withdrawals found", http.StatusNoContent)
+				w.WriteHeader(http.StatusNoContent)
 				return
 			}
 			zap.L().Error("Failed to get withdrawals", zap.Error(err))
@@ -49,6 +63,7 @@ func (h *WithdrawalHandler) GetWithdrawals() http.HandlerFunc {
 			}
 		}
 
+		setNextPageHeaders(w, r, next)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 
@@ -76,14 +91,19 @@ func (h *WithdrawalHandler) CreateWithdrawal() http.HandlerFunc {
 			return
 		}
 
+		if request.Sum <= 0 {
+			http.Error(w, "Sum must be positive", http.StatusBadRequest)
+			return
+		}
+
 		if !luhn.Valid(request.Order) {
 			http.Error(w, "Bad order number", http.StatusUnprocessableEntity)
 			return
 		}
 
-		err := h.withdrawalService.CreateWithdrawal(userID, request.Order, request.Sum)
+		err := h.withdrawalService.CreateWithdrawal(r.Context(), userID, request.Order, request.Sum)
 		if err != nil {
-			if errors.Is(err, ErrNotEnoughBalance) {
+			if errors.Is(err, service.ErrNotEnoughBalance) {
 				http.Error(w, "Not enough balance", http.StatusPaymentRequired)
 				return
 			}
@@ -1,6 +1,10 @@
 package integration
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-resty/resty/v2"
 	"github.com/zavtra-na-rabotu/gophermart/internal/dto"
 	"go.uber.org/zap"
@@ -9,56 +13,131 @@ import (
 	"time"
 )
 
-const defaultTooManyRequestsWaitTime int = 60
+const defaultTooManyRequestsWaitTime = 60 * time.Second
+
+var (
+	ErrOrderNotRegistered = errors.New("order not registered in accrual system")
+	ErrRateLimited        = errors.New("too many requests to accrual system")
+	ErrUpstreamFailure    = errors.New("accrual system failure")
+	ErrMalformedPayload   = errors.New("malformed accrual system response")
+	ErrUnknownStatus      = errors.New("unknown accrual status")
+)
+
+// RateLimitedError is returned when the accrual system answers 429. It matches ErrRateLimited.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrRateLimited, e.RetryAfter)
+}
+
+func (e *RateLimitedError) Is(target error) bool {
+	return target == ErrRateLimited
+}
 
 type AccrualClient struct {
-	client *resty.Client
+	client  *resty.Client
+	limiter *RateLimiter
+	breaker *CircuitBreaker
 }
 
-func NewAccrualClient(url string) *AccrualClient {
+func NewAccrualClient(url string, limiter *RateLimiter, breaker *CircuitBreaker) *AccrualClient {
 	return &AccrualClient{
-		client: resty.New().SetBaseURL(url),
+		client:  resty.New().SetBaseURL(url),
+		limiter: limiter,
+		breaker: breaker,
 	}
 }
 
-func (c *AccrualClient) ProcessOrder(orderNumber string) (*dto.AccrualOrderResponse, error) {
-	response, err := c.client.R().
-		SetResult(&dto.AccrualOrderResponse{}).
-		Get("/api/orders/" + orderNumber)
+// Available reports whether the circuit breaker currently lets requests through.
+func (c *AccrualClient) Available() bool {
+	return c.breaker.Ready()
+}
 
+// ProcessOrder requests accrual information for an order. Besides transport and context errors
+// it returns one of ErrCircuitOpen, ErrOrderNotRegistered, ErrRateLimited, ErrUpstreamFailure,
+// ErrMalformedPayload or ErrUnknownStatus, so the caller can decide per error what to do with the order.
+func (c *AccrualClient) ProcessOrder(ctx context.Context, orderNumber string) (*dto.AccrualOrderResponse, error) {
+	err := c.limiter.Wait(ctx)
 	if err != nil {
-		zap.L().Error("Failed to process order", zap.String("orderNumber", orderNumber), zap.Error(err))
 		return nil, err
 	}
 
-	if response.StatusCode() == http.StatusNoContent {
-		zap.L().Error("Order not registered", zap.String("orderNumber", orderNumber))
+	permit, err := c.breaker.Allow()
+	if err != nil {
 		return nil, err
 	}
 
-	if response.StatusCode() == http.StatusTooManyRequests {
-		retryAfterHeader := response.Header().Get("Retry-After")
+	response, err := c.client.R().
+		SetContext(ctx).
+		Get("/api/orders/" + orderNumber)
+	if err != nil {
+		if ctx.Err() != nil {
+			c.breaker.Cancel(permit)
+			return nil, ctx.Err()
+		}
+		c.breaker.Failure(permit)
+		return nil, fmt.Errorf("%w: %w", ErrUpstreamFailure, err)
+	}
 
-		timeToWait := defaultTooManyRequestsWaitTime
+	switch {
+	case response.StatusCode() == http.StatusOK:
+		c.breaker.Success(permit)
+		return parseAccrualOrderResponse(orderNumber, response.Body())
+	case response.StatusCode() == http.StatusNoContent:
+		c.breaker.Success(permit)
+		return nil, ErrOrderNotRegistered
+	case response.StatusCode() == http.StatusTooManyRequests:
+		c.breaker.Cancel(permit)
+		retryAfter := parseRetryAfter(response.Header().Get("Retry-After"))
 
-		if retryAfterHeader != "" {
-			retryAfter, err := strconv.Atoi(retryAfterHeader)
-			if err == nil {
-				timeToWait = retryAfter
-			} else {
-				zap.L().Error("Invalid Retry-After header", zap.String("orderNumber", orderNumber), zap.Int("Retry-After", retryAfter), zap.Error(err))
-			}
-		}
+		zap.L().Info("Too many requests, pausing accrual requests", zap.Duration("retryAfter", retryAfter))
+		c.limiter.Pause(retryAfter)
+		return nil, &RateLimitedError{RetryAfter: retryAfter}
+	default:
+		c.breaker.Failure(permit)
+		return nil, fmt.Errorf("%w: unexpected status code %d", ErrUpstreamFailure, response.StatusCode())
+	}
+}
 
-		zap.L().Info("Too many requests, waiting before retry", zap.Int("Retry-After (seconds)", timeToWait))
-		time.Sleep(time.Duration(timeToWait) * time.Second)
-		return c.ProcessOrder(orderNumber)
+func parseAccrualOrderResponse(orderNumber string, body []byte) (*dto.AccrualOrderResponse, error) {
+	var accrualResponse dto.AccrualOrderResponse
+	if err := json.Unmarshal(body, &accrualResponse); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedPayload, err)
 	}
 
-	if response.StatusCode() == http.StatusInternalServerError {
-		zap.L().Error("Failed to process order", zap.String("orderNumber", orderNumber))
-		return nil, err
+	if accrualResponse.Order != orderNumber {
+		return nil, fmt.Errorf("%w: requested order %s, got %q", ErrMalformedPayload, orderNumber, accrualResponse.Order)
+	}
+
+	if !accrualResponse.Status.Valid() {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownStatus, accrualResponse.Status)
+	}
+
+	if accrualResponse.Accrual < 0 {
+		return nil, fmt.Errorf("%w: negative accrual %s", ErrMalformedPayload, accrualResponse.Accrual)
+	}
+
+	return &accrualResponse, nil
+}
+
+// parseRetryAfter accepts both forms of the Retry-After header: a number of seconds and an HTTP date.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return defaultTooManyRequestsWaitTime
+	}
+
+	seconds, err := strconv.Atoi(header)
+	if err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	date, err := http.ParseTime(header)
+	if err == nil {
+		return max(time.Until(date), 0)
 	}
 
-	return response.Result().(*dto.AccrualOrderResponse), nil
+	zap.L().Error("Invalid Retry-After header", zap.String("Retry-After", header))
+	return defaultTooManyRequestsWaitTime
 }
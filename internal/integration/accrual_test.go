@@ -0,0 +1,130 @@
+package integration_test
+
+import (
+	"context"
+	"errors"
+	"github.com/zavtra-na-rabotu/gophermart/internal/integration"
+	"github.com/zavtra-na-rabotu/gophermart/internal/integration/accrualfake"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, failureThreshold int) (*integration.AccrualClient, *integration.CircuitBreaker, *accrualfake.Server) {
+	t.Helper()
+
+	server := accrualfake.NewServer()
+	t.Cleanup(server.Close)
+
+	breaker := integration.NewCircuitBreaker(failureThreshold, time.Minute, 1)
+	client := integration.NewAccrualClient(server.URL(), integration.NewRateLimiter(0), breaker)
+
+	return client, breaker, server
+}
+
+func TestAccrualClientProcessOrder(t *testing.T) {
+	client, _, server := newTestClient(t, 5)
+
+	server.SetOrder("1", accrualfake.Processed(model.Amount(72998)))
+	server.SetOrder("2", accrualfake.Response{Body: `{"order":`})
+	server.SetOrder("3", accrualfake.Response{Body: `{"order":"3","status":"DONE"}`})
+	server.SetOrder("4", accrualfake.Response{Body: `{"order":"5","status":"PROCESSED"}`})
+
+	response, err := client.ProcessOrder(context.Background(), "1")
+	if err != nil {
+		t.Fatalf("ProcessOrder() error = %v", err)
+	}
+	if response.Order != "1" || response.Status != "PROCESSED" || response.Accrual != 72998 {
+		t.Errorf("ProcessOrder() = %+v", response)
+	}
+
+	tests := []struct {
+		order string
+		want  error
+	}{
+		{"2", integration.ErrMalformedPayload},
+		{"3", integration.ErrUnknownStatus},
+		{"4", integration.ErrMalformedPayload},
+		{"unknown", integration.ErrOrderNotRegistered},
+	}
+	for _, tt := range tests {
+		_, err := client.ProcessOrder(context.Background(), tt.order)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("ProcessOrder(%q) error = %v, want %v", tt.order, err, tt.want)
+		}
+	}
+}
+
+func TestAccrualClientRateLimited(t *testing.T) {
+	client, breaker, server := newTestClient(t, 1)
+
+	server.SetOrder("1", accrualfake.TooManyRequests(0), accrualfake.Processed(100))
+
+	_, err := client.ProcessOrder(context.Background(), "1")
+	var rateLimited *integration.RateLimitedError
+	if !errors.As(err, &rateLimited) || !errors.Is(err, integration.ErrRateLimited) {
+		t.Fatalf("ProcessOrder() error = %v, want RateLimitedError", err)
+	}
+	if rateLimited.RetryAfter != 0 {
+		t.Errorf("RetryAfter = %v, want 0", rateLimited.RetryAfter)
+	}
+
+	if got := breaker.State(); got != integration.CircuitClosed {
+		t.Errorf("State() = %v after 429, want %v", got, integration.CircuitClosed)
+	}
+
+	if _, err := client.ProcessOrder(context.Background(), "1"); err != nil {
+		t.Errorf("ProcessOrder() error = %v after rate limit", err)
+	}
+}
+
+func TestAccrualClientOpensBreaker(t *testing.T) {
+	client, breaker, server := newTestClient(t, 2)
+
+	server.SetDefault(accrualfake.InternalError())
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.ProcessOrder(context.Background(), "1"); !errors.Is(err, integration.ErrUpstreamFailure) {
+			t.Fatalf("ProcessOrder() error = %v, want %v", err, integration.ErrUpstreamFailure)
+		}
+	}
+
+	if client.Available() {
+		t.Error("Available() = true after threshold failures")
+	}
+
+	if _, err := client.ProcessOrder(context.Background(), "1"); !errors.Is(err, integration.ErrCircuitOpen) {
+		t.Errorf("ProcessOrder() error = %v, want %v", err, integration.ErrCircuitOpen)
+	}
+
+	if got := server.Requests("1"); got != 2 {
+		t.Errorf("Requests() = %d, want 2 with open circuit", got)
+	}
+	if got := breaker.State(); got != integration.CircuitOpen {
+		t.Errorf("State() = %v, want %v", got, integration.CircuitOpen)
+	}
+}
+
+func TestFanOutBatchClientKeepsOrder(t *testing.T) {
+	client, _, server := newTestClient(t, 5)
+
+	server.SetOrder("1", accrualfake.Processed(100))
+	server.SetOrder("3", accrualfake.InternalError())
+	server.SetLatency(10 * time.Millisecond)
+
+	batch := integration.NewFanOutBatchClient(client, 3)
+	results := batch.ProcessOrders(context.Background(), []string{"1", "2", "3"})
+
+	if len(results) != 3 {
+		t.Fatalf("ProcessOrders() returned %d results, want 3", len(results))
+	}
+	if results[0].OrderNumber != "1" || results[0].Err != nil || results[0].Response.Accrual != 100 {
+		t.Errorf("results[0] = %+v", results[0])
+	}
+	if results[1].OrderNumber != "2" || !errors.Is(results[1].Err, integration.ErrOrderNotRegistered) {
+		t.Errorf("results[1] = %+v", results[1])
+	}
+	if results[2].OrderNumber != "3" || !errors.Is(results[2].Err, integration.ErrUpstreamFailure) {
+		t.Errorf("results[2] = %+v", results[2])
+	}
+}
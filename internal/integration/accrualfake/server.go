@@ -0,0 +1,186 @@
+// Package accrualfake provides a scriptable in-process implementation of the accrual system HTTP API
+// for tests and local development.
+package accrualfake
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/zavtra-na-rabotu/gophermart/internal/dto"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Response describes one answer of the fake server.
+// A zero StatusCode means 200 with a JSON body built from Status and Accrual.
+type Response struct {
+	StatusCode int
+	Status     dto.AccrualStatus
+	Accrual    model.Amount
+	// RetryAfter is sent in the Retry-After header of 429 responses.
+	RetryAfter time.Duration
+	// Body, when set, is sent as is instead of the JSON built from Status and Accrual.
+	Body string
+}
+
+func Processed(accrual model.Amount) Response {
+	return Response{Status: dto.AccrualProcessed, Accrual: accrual}
+}
+
+func Status(status dto.AccrualStatus) Response {
+	return Response{Status: status}
+}
+
+func NotRegistered() Response {
+	return Response{StatusCode: http.StatusNoContent}
+}
+
+func TooManyRequests(retryAfter time.Duration) Response {
+	return Response{StatusCode: http.StatusTooManyRequests, RetryAfter: retryAfter}
+}
+
+func InternalError() Response {
+	return Response{StatusCode: http.StatusInternalServerError}
+}
+
+// Server answers GET /api/orders/{number}. Every order has a script: a queue of responses
+// consumed one per request, where the last one is repeated. Orders without a script get
+// the default response, which is 204 unless changed with SetDefault.
+type Server struct {
+	mu              sync.Mutex
+	scripts         map[string][]Response
+	defaultResponse Response
+	rateLimited     int
+	retryAfter      time.Duration
+	latency         time.Duration
+	requests        map[string]int
+
+	server *httptest.Server
+}
+
+// NewServer starts a fake accrual system on a random local port. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		scripts:         make(map[string][]Response),
+		defaultResponse: NotRegistered(),
+		requests:        make(map[string]int),
+	}
+	s.server = httptest.NewServer(s.Handler())
+
+	return s
+}
+
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Handler can be mounted into another server instead of using NewServer.
+func (s *Server) Handler() http.Handler {
+	router := chi.NewRouter()
+	router.Get("/api/orders/{number}", s.getOrder)
+	return router
+}
+
+// SetOrder scripts the responses for an order, replacing the previous script.
+func (s *Server) SetOrder(orderNumber string, responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scripts[orderNumber] = responses
+}
+
+// SetDefault sets the response for orders without a script.
+func (s *Server) SetDefault(response Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.defaultResponse = response
+}
+
+// RateLimit makes the next requests answer 429 with the given Retry-After, regardless of the order.
+func (s *Server) RateLimit(requests int, retryAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rateLimited = requests
+	s.retryAfter = retryAfter
+}
+
+// SetLatency delays every response.
+func (s *Server) SetLatency(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency = latency
+}
+
+// Requests returns how many times an order has been requested.
+func (s *Server) Requests(orderNumber string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.requests[orderNumber]
+}
+
+func (s *Server) next(orderNumber string) (Response, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[orderNumber]++
+
+	if s.rateLimited > 0 {
+		s.rateLimited--
+		return TooManyRequests(s.retryAfter), s.latency
+	}
+
+	script, ok := s.scripts[orderNumber]
+	if !ok || len(script) == 0 {
+		return s.defaultResponse, s.latency
+	}
+
+	if len(script) > 1 {
+		s.scripts[orderNumber] = script[1:]
+	}
+
+	return script[0], s.latency
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request) {
+	orderNumber := chi.URLParam(r, "number")
+	response, latency := s.next(orderNumber)
+
+	if latency > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(latency):
+		}
+	}
+
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests:
+		w.Header().Set("Retry-After", strconv.Itoa(int(response.RetryAfter.Seconds())))
+		http.Error(w, "No more than N requests per minute allowed", http.StatusTooManyRequests)
+	case response.StatusCode != 0 && response.StatusCode != http.StatusOK:
+		w.WriteHeader(response.StatusCode)
+	case response.Body != "":
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(response.Body))
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(dto.AccrualOrderResponse{
+			Order:   orderNumber,
+			Status:  response.Status,
+			Accrual: response.Accrual,
+		})
+	}
+}
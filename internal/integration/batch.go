@@ -0,0 +1,71 @@
+package integration
+
+import (
+	"context"
+	"github.com/zavtra-na-rabotu/gophermart/internal/dto"
+	"sync"
+)
+
+// AccrualResult is the outcome of looking up one order in a batch. Exactly one of Response and Err is set.
+type AccrualResult struct {
+	OrderNumber string
+	Response    *dto.AccrualOrderResponse
+	Err         error
+}
+
+// AccrualProvider looks up accrual information for a single order. AccrualClient is the HTTP implementation.
+type AccrualProvider interface {
+	// Available reports whether requests to the accrual system are currently allowed.
+	Available() bool
+	ProcessOrder(ctx context.Context, orderNumber string) (*dto.AccrualOrderResponse, error)
+}
+
+// BatchAccrualClient looks up accrual information for several orders at once.
+type BatchAccrualClient interface {
+	// Available reports whether requests to the accrual system are currently allowed.
+	Available() bool
+	// ProcessOrders returns one result per order number, in the same order.
+	ProcessOrders(ctx context.Context, orderNumbers []string) []AccrualResult
+}
+
+// FanOutBatchClient implements BatchAccrualClient on top of the single-order endpoint,
+// for accrual systems without a batch API. Up to concurrency requests of a batch run in parallel.
+type FanOutBatchClient struct {
+	client      AccrualProvider
+	concurrency int
+}
+
+func NewFanOutBatchClient(client AccrualProvider, concurrency int) *FanOutBatchClient {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &FanOutBatchClient{client: client, concurrency: concurrency}
+}
+
+func (c *FanOutBatchClient) Available() bool {
+	return c.client.Available()
+}
+
+func (c *FanOutBatchClient) ProcessOrders(ctx context.Context, orderNumbers []string) []AccrualResult {
+	results := make([]AccrualResult, len(orderNumbers))
+	semaphore := make(chan struct{}, c.concurrency)
+
+	var wg sync.WaitGroup
+	for i, orderNumber := range orderNumbers {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			response, err := c.client.ProcessOrder(ctx, orderNumber)
+			results[i] = AccrualResult{OrderNumber: orderNumber, Response: response, Err: err}
+		}()
+	}
+
+	wg.Wait()
+
+	return results
+}
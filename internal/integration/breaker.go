@@ -0,0 +1,191 @@
+package integration
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+var (
+	ErrCircuitOpen = errors.New("accrual system circuit breaker is open")
+)
+
+// CircuitBreakerStats is a snapshot of the breaker published as a metric.
+type CircuitBreakerStats struct {
+	State               CircuitState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	Opens               int          `json:"opens"`
+	Rejected            int          `json:"rejected"`
+}
+
+// CircuitPermit is a call reserved by Allow. A call admitted as a half-open probe is tied to its half-open period,
+// so calls started before the circuit opened, or probes of an earlier period, are not counted as probes.
+type CircuitPermit struct {
+	probe  bool
+	period int
+}
+
+// CircuitBreaker stops calls to the accrual system after failureThreshold consecutive failures.
+// After openTimeout it lets up to halfOpenRequests probe calls through; if they all succeed
+// the circuit closes again, and any failure opens it for another openTimeout.
+type CircuitBreaker struct {
+	mu               sync.Mutex
+	failureThreshold int
+	openTimeout      time.Duration
+	halfOpenRequests int
+
+	state             CircuitState
+	failures          int
+	openedAt          time.Time
+	halfOpenPeriod    int
+	halfOpenInFlight  int
+	halfOpenSucceeded int
+	opens             int
+	rejected          int
+
+	now func() time.Time
+}
+
+func NewCircuitBreaker(failureThreshold int, openTimeout time.Duration, halfOpenRequests int) *CircuitBreaker {
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+	if halfOpenRequests < 1 {
+		halfOpenRequests = 1
+	}
+
+	return &CircuitBreaker{
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+		halfOpenRequests: halfOpenRequests,
+		state:            CircuitClosed,
+		now:              time.Now,
+	}
+}
+
+// Allow reserves a call. Every successful Allow must be followed by Success, Failure or Cancel with the permit.
+func (b *CircuitBreaker) Allow() (CircuitPermit, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.ready() {
+		b.rejected++
+		return CircuitPermit{}, ErrCircuitOpen
+	}
+
+	if b.state == CircuitOpen {
+		b.state = CircuitHalfOpen
+		b.halfOpenPeriod++
+		b.halfOpenInFlight = 0
+		b.halfOpenSucceeded = 0
+	}
+
+	if b.state == CircuitHalfOpen {
+		b.halfOpenInFlight++
+		return CircuitPermit{probe: true, period: b.halfOpenPeriod}, nil
+	}
+
+	return CircuitPermit{}, nil
+}
+
+// Ready reports whether a call would currently be allowed, without reserving it.
+func (b *CircuitBreaker) Ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.ready()
+}
+
+func (b *CircuitBreaker) Success(permit CircuitPermit) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.state == CircuitClosed:
+		b.failures = 0
+	case b.isProbe(permit):
+		b.halfOpenInFlight--
+		b.halfOpenSucceeded++
+		if b.halfOpenSucceeded >= b.halfOpenRequests {
+			b.state = CircuitClosed
+			b.failures = 0
+		}
+	}
+}
+
+func (b *CircuitBreaker) Failure(permit CircuitPermit) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.state == CircuitClosed:
+		b.failures++
+		if b.failures >= b.failureThreshold {
+			b.open()
+		}
+	case b.isProbe(permit):
+		b.halfOpenInFlight--
+		b.failures++
+		b.open()
+	}
+}
+
+// Cancel releases a reserved call whose outcome says nothing about the accrual system, e.g. a cancelled context.
+func (b *CircuitBreaker) Cancel(permit CircuitPermit) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.isProbe(permit) {
+		b.halfOpenInFlight--
+	}
+}
+
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func (b *CircuitBreaker) Stats() CircuitBreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return CircuitBreakerStats{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Opens:               b.opens,
+		Rejected:            b.rejected,
+	}
+}
+
+// isProbe reports whether the permit is a probe of the current half-open period. Outcomes of other calls
+// finishing while the circuit is half-open say nothing about the probes and are ignored.
+func (b *CircuitBreaker) isProbe(permit CircuitPermit) bool {
+	return b.state == CircuitHalfOpen && permit.probe && permit.period == b.halfOpenPeriod
+}
+
+func (b *CircuitBreaker) ready() bool {
+	switch b.state {
+	case CircuitOpen:
+		return b.now().Sub(b.openedAt) >= b.openTimeout
+	case CircuitHalfOpen:
+		return b.halfOpenInFlight+b.halfOpenSucceeded < b.halfOpenRequests
+	default:
+		return true
+	}
+}
+
+func (b *CircuitBreaker) open() {
+	b.state = CircuitOpen
+	b.openedAt = b.now()
+	b.opens++
+}
@@ -0,0 +1,108 @@
+package integration
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(2, time.Minute, 1)
+	breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		permit, err := breaker.Allow()
+		if err != nil {
+			t.Fatalf("Allow() error = %v while closed", err)
+		}
+		breaker.Failure(permit)
+	}
+
+	if got := breaker.State(); got != CircuitOpen {
+		t.Fatalf("State() = %v after threshold failures, want %v", got, CircuitOpen)
+	}
+
+	if _, err := breaker.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow() error = %v while open, want %v", err, ErrCircuitOpen)
+	}
+
+	now = now.Add(time.Minute)
+
+	probe, err := breaker.Allow()
+	if err != nil {
+		t.Fatalf("Allow() error = %v after open timeout", err)
+	}
+
+	if got := breaker.State(); got != CircuitHalfOpen {
+		t.Fatalf("State() = %v after open timeout, want %v", got, CircuitHalfOpen)
+	}
+
+	if _, err := breaker.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Allow() error = %v with probe in flight, want %v", err, ErrCircuitOpen)
+	}
+
+	breaker.Failure(probe)
+
+	if got := breaker.State(); got != CircuitOpen {
+		t.Fatalf("State() = %v after failed probe, want %v", got, CircuitOpen)
+	}
+
+	now = now.Add(time.Minute)
+
+	probe, err = breaker.Allow()
+	if err != nil {
+		t.Fatalf("Allow() error = %v after second open timeout", err)
+	}
+	breaker.Success(probe)
+
+	if got := breaker.State(); got != CircuitClosed {
+		t.Fatalf("State() = %v after successful probe, want %v", got, CircuitClosed)
+	}
+
+	if got := breaker.Stats().Opens; got != 2 {
+		t.Errorf("Stats().Opens = %v, want 2", got)
+	}
+}
+
+func TestCircuitBreakerIgnoresCallsOutsideHalfOpenPeriod(t *testing.T) {
+	now := time.Now()
+	breaker := NewCircuitBreaker(1, time.Minute, 1)
+	breaker.now = func() time.Time { return now }
+
+	// Admitted while closed, finishes only after the circuit has opened and gone half-open
+	late, err := breaker.Allow()
+	if err != nil {
+		t.Fatalf("Allow() error = %v while closed", err)
+	}
+
+	failed, err := breaker.Allow()
+	if err != nil {
+		t.Fatalf("Allow() error = %v while closed", err)
+	}
+	breaker.Failure(failed)
+
+	now = now.Add(time.Minute)
+
+	probe, err := breaker.Allow()
+	if err != nil {
+		t.Fatalf("Allow() error = %v after open timeout", err)
+	}
+
+	for _, finish := range []func(CircuitPermit){breaker.Cancel, breaker.Success, breaker.Failure} {
+		finish(late)
+
+		if got := breaker.State(); got != CircuitHalfOpen {
+			t.Fatalf("State() = %v after a call of the closed circuit finished, want %v", got, CircuitHalfOpen)
+		}
+		if _, err := breaker.Allow(); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Allow() error = %v with probe in flight, want %v", err, ErrCircuitOpen)
+		}
+	}
+
+	breaker.Success(probe)
+
+	if got := breaker.State(); got != CircuitClosed {
+		t.Fatalf("State() = %v after successful probe, want %v", got, CircuitClosed)
+	}
+}
@@ -1,49 +1,310 @@
 package job
 
 import (
+	"context"
+	"errors"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
 	"github.com/zavtra-na-rabotu/gophermart/internal/integration"
-	"github.com/zavtra-na-rabotu/gophermart/internal/service"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/backoff"
 	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// OrderProcessor is the part of service.OrderService the accrual job works with.
+type OrderProcessor interface {
+	ClaimOrders(ctx context.Context, claimedBy string, limit int, lease time.Duration) ([]model.Order, error)
+	RecordAccrualEvents(ctx context.Context, events []model.AccrualEvent) ([]model.AccrualEvent, error)
+	ApplyAccrualEvents(ctx context.Context, ids []int64, claimedBy string) (map[string]error, error)
+	ReplayAccrualEvents(ctx context.Context, olderThan time.Duration, limit int) (int, error)
+	MarkOrderStale(ctx context.Context, orderNumber string, claimedBy string) error
+	ScheduleNextPoll(ctx context.Context, orderNumber string, claimedBy string, delay time.Duration) error
+	PostponePoll(ctx context.Context, orderNumber string, claimedBy string, delay time.Duration) error
+	ReleaseOrder(ctx context.Context, orderNumber string, claimedBy string) error
+}
+
+type AccrualJobOptions struct {
+	// InstanceID identifies this replica as the holder of order leases.
+	InstanceID string
+	Workers    int
+	// ClaimSize is how many orders a pass claims; LookupBatchSize is how many of them a worker looks up at once.
+	ClaimSize       int
+	LookupBatchSize int
+	Lease           time.Duration
+	// BackoffBase and BackoffMax bound the delay between polls of an order without news.
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	// MaxAttempts and MaxAge limit how long an order is polled before it is marked STALE. Zero disables a limit.
+	MaxAttempts int
+	MaxAge      time.Duration
+}
+
 type AccrualJob struct {
-	accrualClient *integration.AccrualClient
-	orderService  *service.OrderService
+	accrualClient integration.BatchAccrualClient
+	orderService  OrderProcessor
+	options       AccrualJobOptions
+	running       atomic.Bool
 }
 
-func NewAccrualJob(accrualClient *integration.AccrualClient, orderService *service.OrderService) *AccrualJob {
-	return &AccrualJob{accrualClient: accrualClient, orderService: orderService}
+func NewAccrualJob(accrualClient integration.BatchAccrualClient, orderService OrderProcessor, options AccrualJobOptions) *AccrualJob {
+	if options.Workers < 1 {
+		options.Workers = 1
+	}
+	if options.LookupBatchSize < 1 {
+		options.LookupBatchSize = 1
+	}
+
+	return &AccrualJob{
+		accrualClient: accrualClient,
+		orderService:  orderService,
+		options:       options,
+	}
 }
 
-func (j *AccrualJob) Start() {
-	orders, err := j.orderService.GetAllNotTerminated()
+// Run polls the accrual system every interval until ctx is cancelled.
+// A pass that is already running is allowed to finish the orders its workers hold.
+func (j *AccrualJob) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			zap.L().Info("Accrual job stopped")
+			return
+		case <-ticker.C:
+			j.Start(ctx)
+		}
+	}
+}
+
+// Start runs a single pass: accrual events left unapplied by a crash are replayed, then pending orders
+// are claimed for this instance, split into batches and processed by a pool of workers.
+// A pass never overlaps with another one of the same job.
+func (j *AccrualJob) Start(ctx context.Context) {
+	if !j.running.CompareAndSwap(false, true) {
+		zap.L().Info("Previous accrual pass is still running, skipping")
+		return
+	}
+	defer j.running.Store(false)
+
+	replayed, err := j.orderService.ReplayAccrualEvents(ctx, j.options.Lease, j.options.ClaimSize)
+	if err != nil {
+		zap.L().Error("Cannot replay accrual events", zap.Error(err))
+	} else if replayed > 0 {
+		zap.L().Info("Replayed unapplied accrual events", zap.Int("events", replayed))
+	}
+
+	if !j.accrualClient.Available() {
+		zap.L().Debug("Accrual system circuit breaker is open, skipping pass")
+		return
+	}
+
+	orders, err := j.orderService.ClaimOrders(ctx, j.options.InstanceID, j.options.ClaimSize, j.options.Lease)
 	if err != nil {
 		zap.L().Error("Cannot get orders to process", zap.Error(err))
 		return
 	}
 
-	for _, order := range orders {
-		accrualResponse, err := j.accrualClient.ProcessOrder(order.Number)
-		if err != nil {
-			zap.L().Error("Cannot process order", zap.Error(err))
-			continue
+	queue := make(chan []model.Order)
+
+	var wg sync.WaitGroup
+	for i := 0; i < j.options.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range queue {
+				j.processBatch(ctx, batch)
+			}
+		}()
+	}
+
+	queued := 0
+enqueue:
+	for queued < len(orders) {
+		batch := orders[queued:min(queued+j.options.LookupBatchSize, len(orders))]
+
+		select {
+		case <-ctx.Done():
+			zap.L().Info("Accrual pass interrupted", zap.Int("remainingOrders", len(orders)-queued))
+			break enqueue
+		case queue <- batch:
+			queued += len(batch)
+		}
+	}
+
+	close(queue)
+	wg.Wait()
+
+	for _, order := range orders[queued:] {
+		j.releaseOrder(context.WithoutCancel(ctx), order.Number)
+	}
+}
+
+// processBatch looks up a batch of orders, records every response as an accrual event
+// and applies the statuses the orders moved to in one transaction.
+func (j *AccrualJob) processBatch(ctx context.Context, orders []model.Order) {
+	numbers := make([]string, len(orders))
+	for i, order := range orders {
+		numbers[i] = order.Number
+	}
+
+	results := j.accrualClient.ProcessOrders(ctx, numbers)
+
+	var events []model.AccrualEvent
+	var received []model.Order
+	for i, result := range results {
+		order := orders[i]
+
+		// A response is kept even if shutdown has started meanwhile, only the lookups that did not finish are given back
+		switch {
+		case result.Err == nil:
+			events = append(events, model.AccrualEvent{
+				OrderNumber: order.Number,
+				Source:      model.PollSource,
+				Status:      string(result.Response.Status),
+				Accrual:     result.Response.Accrual,
+			})
+			received = append(received, order)
+		case ctx.Err() != nil, errors.Is(result.Err, integration.ErrRateLimited), errors.Is(result.Err, integration.ErrCircuitOpen):
+			j.releaseOrder(context.WithoutCancel(ctx), order.Number)
+		case errors.Is(result.Err, integration.ErrOrderNotRegistered):
+			j.scheduleNextPoll(ctx, order)
+		case errors.Is(result.Err, integration.ErrUpstreamFailure):
+			zap.L().Warn("Accrual system failure", zap.String("order", order.Number), zap.Error(result.Err))
+			j.postponePoll(ctx, order)
+		default:
+			zap.L().Error("Cannot process order", zap.String("order", order.Number), zap.Error(result.Err))
+			j.postponePoll(ctx, order)
+		}
+	}
+
+	if len(events) == 0 {
+		return
+	}
+
+	// The responses are already received, so they are recorded and applied even if shutdown has started meanwhile
+	ctx = context.WithoutCancel(ctx)
+
+	recorded, err := j.orderService.RecordAccrualEvents(ctx, events)
+	if err != nil {
+		zap.L().Error("Cannot record accrual events", zap.Error(err))
+		for _, order := range received {
+			j.scheduleNextPoll(ctx, order)
 		}
+		return
+	}
 
-		if accrualResponse.Status == "REGISTERED" {
+	var ids []int64
+	var applied []model.Order
+	var appliedEvents []model.AccrualEvent
+	for i, event := range recorded {
+		if event.Outcome != nil {
+			// Nothing to apply, the order is still waiting in the accrual system
+			j.scheduleNextPoll(ctx, received[i])
 			continue
 		}
 
-		err = j.orderService.UpdateOrder(accrualResponse.Order, accrualResponse.Accrual, accrualResponse.Status)
-		if err != nil {
-			zap.L().Error("Cannot update order", zap.Error(err))
+		ids = append(ids, event.ID)
+		applied = append(applied, received[i])
+		appliedEvents = append(appliedEvents, event)
+	}
+
+	if len(ids) == 0 {
+		return
+	}
+
+	rejected, err := j.orderService.ApplyAccrualEvents(ctx, ids, j.options.InstanceID)
+	if err != nil {
+		// The events stay unapplied and are replayed by a later pass
+		zap.L().Error("Cannot apply accrual events", zap.Error(err))
+		for _, order := range applied {
+			j.scheduleNextPoll(ctx, order)
+		}
+		return
+	}
+
+	for i, order := range applied {
+		err, ok := rejected[order.Number]
+		if ok {
+			switch {
+			case errors.Is(err, repository.ErrOrderLeaseLost):
+				// Another instance polls the order now, its lease is not ours to reschedule
+				zap.L().Warn("Order lease lost, response discarded", zap.String("order", order.Number))
+				continue
+			case !errors.Is(err, repository.ErrOrderStatusUnchanged):
+				zap.L().Error("Cannot update order", zap.String("order", order.Number), zap.Error(err))
+			}
+			j.scheduleNextPoll(ctx, order)
 			continue
 		}
 
 		zap.L().Info(
 			"Order processed",
-			zap.String("order", accrualResponse.Order),
-			zap.String("status", accrualResponse.Status),
-			zap.Float64("accrual", accrualResponse.Accrual),
+			zap.String("order", order.Number),
+			zap.String("status", appliedEvents[i].Status),
+			zap.Stringer("accrual", appliedEvents[i].Accrual),
 		)
 	}
 }
+
+// scheduleNextPoll postpones the next poll of an order with exponential backoff,
+// or gives up on it and marks it STALE once the attempts or age limit is reached.
+func (j *AccrualJob) scheduleNextPoll(ctx context.Context, order model.Order) {
+	if j.overdue(order) {
+		j.markStale(ctx, order)
+		return
+	}
+
+	delay := backoff.Exponential(order.Attempts, j.options.BackoffBase, j.options.BackoffMax)
+
+	err := j.orderService.ScheduleNextPoll(ctx, order.Number, j.options.InstanceID, delay)
+	if err != nil {
+		zap.L().Error("Cannot schedule next poll", zap.String("order", order.Number), zap.Error(err))
+	}
+}
+
+// postponePoll backs off after a failed poll. The failure says nothing about the order,
+// so it is not counted as an attempt and does not bring the order closer to being overdue.
+func (j *AccrualJob) postponePoll(ctx context.Context, order model.Order) {
+	delay := backoff.Exponential(order.Attempts, j.options.BackoffBase, j.options.BackoffMax)
+
+	err := j.orderService.PostponePoll(ctx, order.Number, j.options.InstanceID, delay)
+	if err != nil {
+		zap.L().Error("Cannot postpone next poll", zap.String("order", order.Number), zap.Error(err))
+	}
+}
+
+func (j *AccrualJob) markStale(ctx context.Context, order model.Order) {
+	err := j.orderService.MarkOrderStale(ctx, order.Number, j.options.InstanceID)
+	if err != nil {
+		if errors.Is(err, repository.ErrOrderLeaseLost) {
+			zap.L().Warn("Order lease lost, not marked as stale", zap.String("order", order.Number))
+			return
+		}
+		zap.L().Error("Cannot mark order as stale", zap.String("order", order.Number), zap.Error(err))
+		j.releaseOrder(ctx, order.Number)
+		return
+	}
+
+	zap.L().Warn(
+		"Order marked as stale",
+		zap.String("order", order.Number),
+		zap.Int("attempts", order.Attempts+1),
+		zap.Time("uploadedAt", order.UploadedAt),
+	)
+}
+
+func (j *AccrualJob) overdue(order model.Order) bool {
+	return (j.options.MaxAttempts > 0 && order.Attempts+1 >= j.options.MaxAttempts) ||
+		(j.options.MaxAge > 0 && time.Since(order.UploadedAt) > j.options.MaxAge)
+}
+
+func (j *AccrualJob) releaseOrder(ctx context.Context, orderNumber string) {
+	err := j.orderService.ReleaseOrder(ctx, orderNumber, j.options.InstanceID)
+	if err != nil {
+		zap.L().Error("Cannot release order lease", zap.String("order", orderNumber), zap.Error(err))
+	}
+}
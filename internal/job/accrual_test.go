@@ -0,0 +1,286 @@
+package job
+
+import (
+	"context"
+	"github.com/zavtra-na-rabotu/gophermart/internal/dto"
+	"github.com/zavtra-na-rabotu/gophermart/internal/integration"
+	"github.com/zavtra-na-rabotu/gophermart/internal/integration/accrualfake"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeOrderProcessor keeps orders in memory and records what the job did with each of them.
+type fakeOrderProcessor struct {
+	mu        sync.Mutex
+	orders    []model.Order
+	events    []model.AccrualEvent
+	updated   map[string]model.OrderUpdate
+	stale     map[string]bool
+	scheduled map[string]time.Duration
+	postponed map[string]time.Duration
+	released  map[string]bool
+}
+
+func newFakeOrderProcessor(orders ...model.Order) *fakeOrderProcessor {
+	return &fakeOrderProcessor{
+		orders:    orders,
+		updated:   make(map[string]model.OrderUpdate),
+		stale:     make(map[string]bool),
+		scheduled: make(map[string]time.Duration),
+		postponed: make(map[string]time.Duration),
+		released:  make(map[string]bool),
+	}
+}
+
+func (p *fakeOrderProcessor) ClaimOrders(_ context.Context, _ string, limit int, _ time.Duration) ([]model.Order, error) {
+	return p.orders[:min(limit, len(p.orders))], nil
+}
+
+func (p *fakeOrderProcessor) RecordAccrualEvents(_ context.Context, events []model.AccrualEvent) ([]model.AccrualEvent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	recorded := make([]model.AccrualEvent, len(events))
+	for i, event := range events {
+		event.ID = int64(len(p.events) + 1)
+		if event.Status == "REGISTERED" {
+			outcome := model.EventIgnored
+			event.Outcome = &outcome
+		}
+
+		p.events = append(p.events, event)
+		recorded[i] = event
+	}
+	return recorded, nil
+}
+
+func (p *fakeOrderProcessor) ApplyAccrualEvents(_ context.Context, ids []int64, _ string) (map[string]error, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, id := range ids {
+		event := p.events[id-1]
+		p.updated[event.OrderNumber] = model.OrderUpdate{Number: event.OrderNumber, Status: model.OrderStatus(event.Status), Accrual: event.Accrual}
+	}
+	return map[string]error{}, nil
+}
+
+func (p *fakeOrderProcessor) ReplayAccrualEvents(_ context.Context, _ time.Duration, _ int) (int, error) {
+	return 0, nil
+}
+
+func (p *fakeOrderProcessor) MarkOrderStale(_ context.Context, orderNumber string, _ string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stale[orderNumber] = true
+	return nil
+}
+
+func (p *fakeOrderProcessor) ScheduleNextPoll(_ context.Context, orderNumber string, _ string, delay time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.scheduled[orderNumber] = delay
+	return nil
+}
+
+func (p *fakeOrderProcessor) PostponePoll(_ context.Context, orderNumber string, _ string, delay time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.postponed[orderNumber] = delay
+	return nil
+}
+
+func (p *fakeOrderProcessor) ReleaseOrder(_ context.Context, orderNumber string, _ string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.released[orderNumber] = true
+	return nil
+}
+
+func TestAccrualJobStart(t *testing.T) {
+	server := accrualfake.NewServer()
+	defer server.Close()
+
+	server.SetOrder("1", accrualfake.Processed(500))
+	server.SetOrder("2", accrualfake.Status("INVALID"))
+	server.SetOrder("3", accrualfake.Status("REGISTERED"))
+	server.SetOrder("5", accrualfake.InternalError())
+
+	now := time.Now()
+	processor := newFakeOrderProcessor(
+		model.Order{Number: "1", UploadedAt: now},
+		model.Order{Number: "2", UploadedAt: now},
+		model.Order{Number: "3", UploadedAt: now},
+		model.Order{Number: "4", UploadedAt: now},
+		model.Order{Number: "5", UploadedAt: now, Attempts: 9},
+		model.Order{Number: "6", UploadedAt: now.Add(-2 * time.Hour)},
+		model.Order{Number: "7", UploadedAt: now, Attempts: 9},
+	)
+
+	breaker := integration.NewCircuitBreaker(10, time.Minute, 1)
+	client := integration.NewAccrualClient(server.URL(), integration.NewRateLimiter(0), breaker)
+
+	job := NewAccrualJob(integration.NewFanOutBatchClient(client, 2), processor, AccrualJobOptions{
+		InstanceID:      "test",
+		Workers:         2,
+		ClaimSize:       10,
+		LookupBatchSize: 2,
+		Lease:           time.Minute,
+		BackoffBase:     time.Second,
+		BackoffMax:      time.Minute,
+		MaxAttempts:     10,
+		MaxAge:          time.Hour,
+	})
+	job.Start(context.Background())
+
+	wantUpdates := map[string]model.OrderUpdate{
+		"1": {Number: "1", Status: model.Processed, Accrual: 500},
+		"2": {Number: "2", Status: model.Invalid},
+	}
+	if len(processor.updated) != len(wantUpdates) {
+		t.Errorf("updated %v, want %v", processor.updated, wantUpdates)
+	}
+	for number, want := range wantUpdates {
+		if got := processor.updated[number]; got != want {
+			t.Errorf("update of order %s = %+v, want %+v", number, got, want)
+		}
+	}
+
+	for _, number := range []string{"3", "4"} {
+		if delay, ok := processor.scheduled[number]; !ok || delay > time.Second {
+			t.Errorf("next poll of order %s is scheduled in %v, want up to %v", number, delay, time.Second)
+		}
+	}
+
+	// Orders past the attempts or age limit are given up on and not polled any more
+	for _, number := range []string{"6", "7"} {
+		if !processor.stale[number] {
+			t.Errorf("order %s past the polling limits is not marked as stale", number)
+		}
+		if _, ok := processor.scheduled[number]; ok {
+			t.Errorf("next poll of stale order %s is scheduled", number)
+		}
+	}
+	if len(processor.stale) != 2 {
+		t.Errorf("stale orders %v, want 6 and 7", processor.stale)
+	}
+
+	// A failure of the accrual system is backed off without counting as an attempt
+	if _, ok := processor.scheduled["5"]; ok {
+		t.Error("accrual system failure is counted as a poll attempt")
+	}
+	if delay, ok := processor.postponed["5"]; !ok || delay < 30*time.Second {
+		t.Errorf("next poll after accrual system failure is postponed by %v, want the backoff of the previous attempts", delay)
+	}
+}
+
+func TestAccrualJobWithoutPollingLimits(t *testing.T) {
+	server := accrualfake.NewServer()
+	defer server.Close()
+
+	processor := newFakeOrderProcessor(model.Order{Number: "1", UploadedAt: time.Now().Add(-time.Hour)})
+
+	breaker := integration.NewCircuitBreaker(10, time.Minute, 1)
+	client := integration.NewAccrualClient(server.URL(), integration.NewRateLimiter(0), breaker)
+
+	job := NewAccrualJob(integration.NewFanOutBatchClient(client, 1), processor, AccrualJobOptions{
+		InstanceID:  "test",
+		ClaimSize:   10,
+		BackoffBase: time.Second,
+		BackoffMax:  time.Minute,
+	})
+	job.Start(context.Background())
+
+	if delay, ok := processor.scheduled["1"]; !ok || delay > time.Second {
+		t.Errorf("next poll is scheduled in %v, want up to %v", delay, time.Second)
+	}
+	if processor.stale["1"] {
+		t.Error("order is marked as stale without polling limits")
+	}
+}
+
+func TestAccrualJobReleasesRateLimitedOrders(t *testing.T) {
+	server := accrualfake.NewServer()
+	defer server.Close()
+
+	server.SetDefault(accrualfake.Processed(100))
+	server.RateLimit(1, 0)
+
+	processor := newFakeOrderProcessor(model.Order{Number: "1", UploadedAt: time.Now()})
+
+	breaker := integration.NewCircuitBreaker(10, time.Minute, 1)
+	client := integration.NewAccrualClient(server.URL(), integration.NewRateLimiter(0), breaker)
+
+	job := NewAccrualJob(integration.NewFanOutBatchClient(client, 1), processor, AccrualJobOptions{
+		InstanceID:  "test",
+		ClaimSize:   10,
+		MaxAttempts: 10,
+		MaxAge:      time.Hour,
+	})
+	job.Start(context.Background())
+
+	if !processor.released["1"] {
+		t.Error("rate limited order is not released")
+	}
+	if len(processor.updated) != 0 || len(processor.scheduled) != 0 {
+		t.Errorf("rate limited order is updated %v or scheduled %v", processor.updated, processor.scheduled)
+	}
+}
+
+// cancellingBatchClient answers the first order of a batch and cancels the pass while the rest are looked up.
+type cancellingBatchClient struct {
+	cancel context.CancelFunc
+}
+
+func (c *cancellingBatchClient) Available() bool {
+	return true
+}
+
+func (c *cancellingBatchClient) ProcessOrders(ctx context.Context, orderNumbers []string) []integration.AccrualResult {
+	c.cancel()
+
+	results := make([]integration.AccrualResult, len(orderNumbers))
+	for i, number := range orderNumbers {
+		results[i] = integration.AccrualResult{OrderNumber: number, Err: ctx.Err()}
+	}
+	results[0] = integration.AccrualResult{
+		OrderNumber: orderNumbers[0],
+		Response:    &dto.AccrualOrderResponse{Order: orderNumbers[0], Status: dto.AccrualProcessed, Accrual: 100},
+	}
+
+	return results
+}
+
+func TestAccrualJobKeepsResponsesReceivedBeforeShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	processor := newFakeOrderProcessor(
+		model.Order{Number: "1", UploadedAt: time.Now()},
+		model.Order{Number: "2", UploadedAt: time.Now()},
+	)
+
+	job := NewAccrualJob(&cancellingBatchClient{cancel: cancel}, processor, AccrualJobOptions{
+		InstanceID:      "test",
+		ClaimSize:       10,
+		LookupBatchSize: 2,
+	})
+	job.Start(ctx)
+
+	want := model.OrderUpdate{Number: "1", Status: model.Processed, Accrual: 100}
+	if got := processor.updated["1"]; got != want {
+		t.Errorf("update of order 1 = %+v, want %+v", got, want)
+	}
+	if processor.released["1"] {
+		t.Error("order with a received response is released")
+	}
+	if !processor.released["2"] {
+		t.Error("order whose lookup was interrupted is not released")
+	}
+}
@@ -0,0 +1,58 @@
+package job
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"time"
+)
+
+// CleanupTask removes data that is no longer needed and returns how many rows it removed.
+type CleanupTask struct {
+	Name string
+	Run  func(ctx context.Context) (int64, error)
+}
+
+// CleanupJob periodically runs tasks that keep tables from growing without bound.
+type CleanupJob struct {
+	tasks []CleanupTask
+}
+
+func NewCleanupJob(tasks ...CleanupTask) *CleanupJob {
+	return &CleanupJob{tasks: tasks}
+}
+
+// Run runs every task right away and then every interval until ctx is cancelled.
+func (j *CleanupJob) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		j.Start(ctx)
+
+		select {
+		case <-ctx.Done():
+			zap.L().Info("Cleanup job stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Start runs every task once. A failing task is logged and does not stop the others.
+func (j *CleanupJob) Start(ctx context.Context) {
+	for _, task := range j.tasks {
+		if ctx.Err() != nil {
+			return
+		}
+
+		removed, err := task.Run(ctx)
+		if err != nil {
+			zap.L().Error("Cleanup task failed", zap.String("task", task.Name), zap.Error(err))
+			continue
+		}
+
+		if removed > 0 {
+			zap.L().Info("Cleanup task removed rows", zap.String("task", task.Name), zap.Int64("rows", removed))
+		}
+	}
+}
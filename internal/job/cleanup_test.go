@@ -0,0 +1,23 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCleanupJobRunsEveryTask(t *testing.T) {
+	var ran []string
+	task := func(name string, err error) CleanupTask {
+		return CleanupTask{Name: name, Run: func(context.Context) (int64, error) {
+			ran = append(ran, name)
+			return 1, err
+		}}
+	}
+
+	NewCleanupJob(task("first", errors.New("failed")), task("second", nil)).Start(context.Background())
+
+	if len(ran) != 2 || ran[0] != "first" || ran[1] != "second" {
+		t.Errorf("ran %v, want every task in order despite the failure", ran)
+	}
+}
@@ -14,9 +14,16 @@ const (
 	authorizationHeader string     = "Authorization"
 	authorizationPrefix string     = "Bearer "
 	UserIDKey           contextKey = "userId"
+	ClaimsKey           contextKey = "claims"
 )
 
-func AuthorizationMiddleware(jwtService *security.JwtService) func(next http.Handler) http.Handler {
+// RevocationChecker tells whether an access token has been revoked before its expiry, for example on logout
+// or by a password change.
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, claims *security.CustomClaims) (bool, error)
+}
+
+func AuthorizationMiddleware(jwtService *security.JwtService, revocationChecker RevocationChecker) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get(authorizationHeader)
@@ -34,12 +41,25 @@ func AuthorizationMiddleware(jwtService *security.JwtService) func(next http.Han
 
 			claims, err := jwtService.ValidateJwtToken(token)
 			if err != nil {
-				zap.L().Error("Error validating token", zap.String("token", token), zap.Error(err))
+				zap.L().Info("Error validating token", zap.Error(err))
 				http.Error(w, err.Error(), http.StatusUnauthorized)
 				return
 			}
 
+			revoked, err := revocationChecker.IsRevoked(r.Context(), claims)
+			if err != nil {
+				zap.L().Error("Error checking token revocation", zap.Error(err))
+				http.Error(w, "Failed to check token", http.StatusInternalServerError)
+				return
+			}
+
+			if revoked {
+				http.Error(w, "Token revoked", http.StatusUnauthorized)
+				return
+			}
+
 			ctx := context.WithValue(r.Context(), UserIDKey, claims.UserID)
+			ctx = context.WithValue(ctx, ClaimsKey, claims)
 
 			r = r.WithContext(ctx)
 
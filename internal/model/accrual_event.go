@@ -0,0 +1,38 @@
+package model
+
+import "time"
+
+type AccrualEventSource string
+
+const (
+	PollSource     AccrualEventSource = "POLL"
+	CallbackSource AccrualEventSource = "CALLBACK"
+)
+
+type AccrualEventOutcome string
+
+const (
+	// EventApplied means the event moved the order to its status.
+	EventApplied AccrualEventOutcome = "APPLIED"
+	// EventUnchanged means the order already had the status of the event.
+	EventUnchanged AccrualEventOutcome = "UNCHANGED"
+	// EventRejected means the order is unknown or the status transition is illegal; Error says why.
+	EventRejected AccrualEventOutcome = "REJECTED"
+	// EventIgnored means the event carries no status to apply, like REGISTERED.
+	EventIgnored AccrualEventOutcome = "IGNORED"
+)
+
+// AccrualEvent is an accrual system response as it was received, recorded before it is applied to the order.
+// Status is the accrual system status, which may be REGISTERED, so it is not an OrderStatus.
+// AppliedAt, Outcome and Error are set once the event has been applied.
+type AccrualEvent struct {
+	ID          int64
+	OrderNumber string
+	Source      AccrualEventSource
+	Status      string
+	Accrual     Amount
+	ReceivedAt  time.Time
+	AppliedAt   *time.Time
+	Outcome     *AccrualEventOutcome
+	Error       *string
+}
@@ -0,0 +1,185 @@
+package model
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// amountScale is the number of minor units in one point.
+const (
+	amountScale     = 100
+	amountPrecision = 2
+)
+
+var (
+	ErrInvalidAmount = errors.New("invalid amount")
+)
+
+// Amount is a number of loyalty points stored in minor units (hundredths of a point).
+// It is rendered in JSON as a plain decimal number, e.g. 500.5.
+type Amount int64
+
+// ParseAmount parses a decimal string such as "500.5" without going through binary floating point.
+// Amounts come from clients and the accrual system, so negative values and fractions of a minor unit,
+// such as 1.005, are rejected rather than rounded. Trailing zeros, as in 1.500, are fine.
+func ParseAmount(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s[0] == '-' {
+		return 0, ErrInvalidAmount
+	}
+
+	if s[0] == '+' {
+		s = s[1:]
+	}
+
+	if strings.ContainsAny(s, "eE") {
+		return parseExponentAmount(s)
+	}
+
+	integerPart, fractionPart, _ := strings.Cut(s, ".")
+	if integerPart == "" && fractionPart == "" {
+		return 0, ErrInvalidAmount
+	}
+	if !isDigits(integerPart) || !isDigits(fractionPart) {
+		return 0, ErrInvalidAmount
+	}
+
+	var units int64
+	if integerPart != "" {
+		whole, err := strconv.ParseInt(integerPart, 10, 64)
+		if err != nil || whole > (1<<63-1)/amountScale-1 {
+			return 0, ErrInvalidAmount
+		}
+		units = whole * amountScale
+	}
+
+	if len(fractionPart) > amountPrecision {
+		if strings.Trim(fractionPart[amountPrecision:], "0") != "" {
+			return 0, ErrInvalidAmount
+		}
+		fractionPart = fractionPart[:amountPrecision]
+	}
+	fractionPart += strings.Repeat("0", amountPrecision-len(fractionPart))
+
+	fraction, err := strconv.ParseInt(fractionPart, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidAmount
+	}
+
+	return Amount(units + fraction), nil
+}
+
+// parseExponentAmount handles numbers in scientific notation, which encoding/json may produce for large values.
+func parseExponentAmount(s string) (Amount, error) {
+	mantissa, exponent, _ := strings.Cut(strings.ToLower(s), "e")
+
+	shift, err := strconv.Atoi(exponent)
+	if err != nil || shift > 18 || shift < -18 {
+		return 0, ErrInvalidAmount
+	}
+
+	integerPart, fractionPart, _ := strings.Cut(mantissa, ".")
+	if !isDigits(integerPart) || !isDigits(fractionPart) || integerPart+fractionPart == "" {
+		return 0, ErrInvalidAmount
+	}
+
+	digits := integerPart + fractionPart
+	point := len(integerPart) + shift
+	switch {
+	case point <= 0:
+		digits = strings.Repeat("0", -point+1) + digits
+		point = 1
+	case point > len(digits):
+		digits += strings.Repeat("0", point-len(digits))
+	}
+
+	return ParseAmount(digits[:point] + "." + digits[point:])
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String renders the amount without trailing zeros: 50050 -> "500.5", 100 -> "1".
+func (a Amount) String() string {
+	units := int64(a)
+
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+
+	whole := units / amountScale
+	fraction := units % amountScale
+	if fraction == 0 {
+		return fmt.Sprintf("%s%d", sign, whole)
+	}
+
+	fractionString := strings.TrimRight(fmt.Sprintf("%0*d", amountPrecision, fraction), "0")
+	return fmt.Sprintf("%s%d.%s", sign, whole, fractionString)
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalJSON accepts only JSON numbers, as the API sends them. Strings are rejected.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	value := string(data)
+	if value == "null" {
+		return nil
+	}
+
+	if strings.HasPrefix(value, `"`) {
+		return fmt.Errorf("%w: %s", ErrInvalidAmount, data)
+	}
+
+	amount, err := ParseAmount(value)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidAmount, data)
+	}
+
+	*a = amount
+	return nil
+}
+
+// Value stores the amount as a BIGINT number of minor units.
+func (a Amount) Value() (driver.Value, error) {
+	return int64(a), nil
+}
+
+func (a *Amount) Scan(src any) error {
+	switch value := src.(type) {
+	case int64:
+		*a = Amount(value)
+	case int32:
+		*a = Amount(value)
+	case []byte:
+		units, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidAmount, value)
+		}
+		*a = Amount(units)
+	case string:
+		units, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidAmount, value)
+		}
+		*a = Amount(units)
+	case nil:
+		*a = 0
+	default:
+		return fmt.Errorf("%w: unsupported type %T", ErrInvalidAmount, src)
+	}
+
+	return nil
+}
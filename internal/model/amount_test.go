@@ -0,0 +1,118 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		name    string
+		arg     string
+		want    Amount
+		wantErr bool
+	}{
+		{name: "Integer", arg: "500", want: 50000},
+		{name: "One fractional digit", arg: "500.5", want: 50050},
+		{name: "Two fractional digits", arg: "0.01", want: 1},
+		{name: "Trailing zeros", arg: "1.500", want: 150},
+		{name: "Fraction of a minor unit", arg: "1.005", wantErr: true},
+		{name: "Fraction of a minor unit with exponent", arg: "1005e-3", wantErr: true},
+		{name: "Negative", arg: "-12.3", wantErr: true},
+		{name: "Negative zero", arg: "-0", wantErr: true},
+		{name: "Leading point", arg: ".5", want: 50},
+		{name: "Exponent", arg: "1.5e2", want: 15000},
+		{name: "Negative exponent", arg: "15e-1", want: 150},
+		{name: "Empty", arg: "", wantErr: true},
+		{name: "Sign only", arg: "-", wantErr: true},
+		{name: "Letters", arg: "12a", wantErr: true},
+		{name: "Two points", arg: "1.2.3", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseAmount(test.arg)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ParseAmount() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("ParseAmount() = %v, want %v", int64(got), int64(test.want))
+			}
+		})
+	}
+}
+
+func TestAmountString(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  Amount
+		want string
+	}{
+		{name: "Zero", arg: 0, want: "0"},
+		{name: "Integer", arg: 50000, want: "500"},
+		{name: "One fractional digit", arg: 50050, want: "500.5"},
+		{name: "Two fractional digits", arg: 1, want: "0.01"},
+		{name: "Negative", arg: -1230, want: "-12.3"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.arg.String(); got != test.want {
+				t.Errorf("String() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	var payload struct {
+		Sum Amount `json:"sum"`
+	}
+
+	err := json.Unmarshal([]byte(`{"sum": 0.1}`), &payload)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	payload.Sum += Amount(20)
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	if string(data) != `{"sum":0.3}` {
+		t.Errorf("Marshal() = %s, want %s", data, `{"sum":0.3}`)
+	}
+}
+
+func TestAmountUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Amount
+		wantErr bool
+	}{
+		{name: "Number", data: `5.25`, want: 525},
+		{name: "Null", data: `null`, want: 0},
+		{name: "String", data: `"5"`, wantErr: true},
+		{name: "Unterminated string", data: `"5`, wantErr: true},
+		{name: "Unopened string", data: `5"`, wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got Amount
+			err := got.UnmarshalJSON([]byte(test.data))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("UnmarshalJSON() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidAmount) {
+				t.Errorf("UnmarshalJSON() error = %v, want %v", err, ErrInvalidAmount)
+			}
+			if got != test.want {
+				t.Errorf("UnmarshalJSON() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,6 @@ package model
 type Balance struct {
 	ID        int
 	UserID    int
-	Current   float64
-	Withdrawn float64
+	Current   Amount
+	Withdrawn Amount
 }
@@ -0,0 +1,26 @@
+package model
+
+import "time"
+
+type LedgerEntryType string
+
+const (
+	AccrualEntry    LedgerEntryType = "ACCRUAL"
+	WithdrawalEntry LedgerEntryType = "WITHDRAWAL"
+	AdjustmentEntry LedgerEntryType = "ADJUSTMENT"
+	ReversalEntry   LedgerEntryType = "REVERSAL"
+)
+
+// LedgerEntry is a single append-only movement of points on a user's balance.
+// Amount is signed: accruals are positive, withdrawals are negative.
+type LedgerEntry struct {
+	ID           int
+	UserID       int
+	Type         LedgerEntryType
+	Amount       Amount
+	OrderNumber  *string
+	WithdrawalID *int
+	ReversalOf   *int
+	Comment      *string
+	CreatedAt    time.Time
+}
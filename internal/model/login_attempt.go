@@ -0,0 +1,18 @@
+package model
+
+// LoginAttemptKind is what failed logins, or other throttled requests, are counted by.
+type LoginAttemptKind string
+
+const (
+	LoginAttemptByLogin LoginAttemptKind = "login"
+	LoginAttemptByIP    LoginAttemptKind = "ip"
+	// Password reset requests are counted apart, so they never delay or lock out logins.
+	ResetRequestByLogin LoginAttemptKind = "reset_login"
+	ResetRequestByIP    LoginAttemptKind = "reset_ip"
+)
+
+// LoginAttemptKey identifies one failed login counter.
+type LoginAttemptKey struct {
+	Kind LoginAttemptKind
+	Key  string
+}
@@ -11,6 +11,9 @@ const (
 	Processing OrderStatus = "PROCESSING"
 	Invalid    OrderStatus = "INVALID"
 	Processed  OrderStatus = "PROCESSED"
+	// Stale marks an order the accrual system never reported on within the polling limits.
+	// It is internal: the API shows it as INVALID.
+	Stale OrderStatus = "STALE"
 )
 
 type Order struct {
@@ -18,6 +21,71 @@ type Order struct {
 	UserID     int
 	Number     string
 	Status     OrderStatus
-	Accrual    float64
+	Accrual    Amount
 	UploadedAt time.Time
+	Attempts   int
+}
+
+// OrderUpdate is a status change of an order reported by the accrual system.
+type OrderUpdate struct {
+	Number  string
+	Status  OrderStatus
+	Accrual Amount
+}
+
+type OrderUploadResult string
+
+const (
+	UploadAccepted        OrderUploadResult = "ACCEPTED"
+	UploadAlreadyUploaded OrderUploadResult = "ALREADY_UPLOADED"
+	UploadOwnedByAnother  OrderUploadResult = "OWNED_BY_ANOTHER_USER"
+	UploadInvalid         OrderUploadResult = "INVALID"
+)
+
+// OrderUpload is the outcome of uploading one order number of a batch.
+type OrderUpload struct {
+	Number string
+	Result OrderUploadResult
+}
+
+// OrderStatusChange is an entry of the order status history.
+type OrderStatusChange struct {
+	Status    OrderStatus
+	Accrual   Amount
+	ChangedAt time.Time
+}
+
+// orderTransitions lists the statuses an order may move to from each non-terminal status.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	New:        {Processing, Invalid, Processed, Stale},
+	Processing: {Invalid, Processed, Stale},
+}
+
+// Valid reports whether s is a status of the API. STALE is not one of them.
+func (s OrderStatus) Valid() bool {
+	switch s {
+	case New, Processing, Invalid, Processed:
+		return true
+	default:
+		return false
+	}
+}
+
+// APIStatus returns the status shown to users: a stale order gets no accrual, so it is shown as INVALID.
+func (s OrderStatus) APIStatus() OrderStatus {
+	if s == Stale {
+		return Invalid
+	}
+	return s
+}
+
+// CanTransitionTo reports whether an order in status s may be moved to next.
+// Staying in the same status is not a transition.
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range orderTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
 }
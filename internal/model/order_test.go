@@ -0,0 +1,54 @@
+package model
+
+import "testing"
+
+func TestOrderStatusCanTransitionTo(t *testing.T) {
+	tests := []struct {
+		name string
+		from OrderStatus
+		to   OrderStatus
+		want bool
+	}{
+		{name: "New to processing", from: New, to: Processing, want: true},
+		{name: "New to processed", from: New, to: Processed, want: true},
+		{name: "New to invalid", from: New, to: Invalid, want: true},
+		{name: "Processing to processed", from: Processing, to: Processed, want: true},
+		{name: "Processing to invalid", from: Processing, to: Invalid, want: true},
+		{name: "Processing to new", from: Processing, to: New, want: false},
+		{name: "Processing to processing", from: Processing, to: Processing, want: false},
+		{name: "Processed to processed", from: Processed, to: Processed, want: false},
+		{name: "Processed to invalid", from: Processed, to: Invalid, want: false},
+		{name: "Invalid to processed", from: Invalid, to: Processed, want: false},
+		{name: "Processing to stale", from: Processing, to: Stale, want: true},
+		{name: "Stale to processed", from: Stale, to: Processed, want: false},
+		{name: "Unknown status", from: New, to: OrderStatus("REGISTERED"), want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.from.CanTransitionTo(test.to); got != test.want {
+				t.Errorf("CanTransitionTo() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOrderStatusAPIStatus(t *testing.T) {
+	tests := []struct {
+		status OrderStatus
+		want   OrderStatus
+	}{
+		{status: New, want: New},
+		{status: Processing, want: Processing},
+		{status: Invalid, want: Invalid},
+		{status: Processed, want: Processed},
+		{status: Stale, want: Invalid},
+	}
+	for _, test := range tests {
+		t.Run(string(test.status), func(t *testing.T) {
+			if got := test.status.APIStatus(); got != test.want {
+				t.Errorf("APIStatus() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/cursor"
+	"time"
+)
+
+type SortDirection string
+
+const (
+	SortAscending  SortDirection = "asc"
+	SortDescending SortDirection = "desc"
+)
+
+// PageQuery selects one page of a user's list, ordered by time and then by id.
+type PageQuery struct {
+	// Limit is the page size; zero returns the whole list in one page.
+	Limit int
+	// After is the cursor of the last row of the previous page, nil for the first page.
+	After *cursor.Cursor
+	Sort  SortDirection
+	// From is inclusive and To is exclusive; zero values leave the range open.
+	From time.Time
+	To   time.Time
+}
+
+type OrderQuery struct {
+	PageQuery
+	// Statuses limits the orders to the given statuses; empty means any status.
+	Statuses []OrderStatus
+}
@@ -0,0 +1,24 @@
+package model
+
+import "time"
+
+// TokenPair is issued on registration, login and refresh: a short-lived access token
+// and a single-use refresh token to get the next pair with.
+type TokenPair struct {
+	AccessToken          string
+	AccessTokenExpiresAt time.Time
+	RefreshToken         string
+}
+
+// RefreshToken is the stored form of a refresh token. Only the hash of the token is kept.
+// Tokens rotated from one another share FamilyID, so a whole chain can be revoked at once.
+type RefreshToken struct {
+	ID        int64
+	UserID    int
+	FamilyID  string
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
@@ -1,7 +1,19 @@
 package model
 
+import "time"
+
 type User struct {
 	ID       int
 	Login    string
 	Password string
 }
+
+// PasswordResetToken is the stored form of a password reset token. Only the hash of the token is kept.
+type PasswordResetToken struct {
+	ID        int64
+	UserID    int
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
@@ -6,6 +6,6 @@ type Withdrawal struct {
 	ID          int
 	UserID      int
 	OrderNumber string
-	Sum         float64
+	Sum         Amount
 	ProcessedAt time.Time
 }
@@ -0,0 +1,72 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+	"time"
+)
+
+// PasswordReset carries a password reset token to the user who requested it.
+type PasswordReset struct {
+	Login     string    `json:"login"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Notifier delivers messages to users. Users only have a login, so a real implementation
+// is expected to resolve the contact address on its side.
+type Notifier interface {
+	SendPasswordReset(ctx context.Context, reset PasswordReset) error
+}
+
+// LogNotifier writes notifications to the log. It is meant for local development only: the log then holds
+// live reset tokens.
+type LogNotifier struct{}
+
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+func (n *LogNotifier) SendPasswordReset(_ context.Context, reset PasswordReset) error {
+	zap.L().Info("Password reset requested",
+		zap.String("login", reset.Login),
+		zap.String("token", reset.Token),
+		zap.Time("expiresAt", reset.ExpiresAt),
+	)
+	return nil
+}
+
+// FileNotifier appends notifications to a file as JSON lines, so local tooling and tests can pick them up.
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+func (n *FileNotifier) SendPasswordReset(_ context.Context, reset PasswordReset) error {
+	line, err := json.Marshal(reset)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(line, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
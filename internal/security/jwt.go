@@ -2,53 +2,107 @@ package security
 
 import (
 	"errors"
+	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"go.uber.org/zap"
 	"time"
 )
 
+// JwtService signs tokens either with a shared HS256 secret or with an asymmetric key. With asymmetric keys
+// every token carries the ID of its key in the kid header, and tokens of any of the verification keys are accepted,
+// so a new signing key can be rolled out while tokens of the previous one are still valid.
 type JwtService struct {
 	jwtSecret   []byte
+	signingKey  *Key
+	keys        map[string]*Key
+	methods     []string
 	jwtLifetime time.Duration
 }
 
 type CustomClaims struct {
 	jwt.RegisteredClaims
 	UserID int `json:"user_id"`
+	// TokenVersion is the token version of the user at issue time. Bumping the version,
+	// as on a password change, invalidates every token issued before.
+	TokenVersion int `json:"ver"`
 }
 
 var (
 	ErrInvalidToken = errors.New("invalid token")
+	ErrUnknownKey   = errors.New("unknown signing key")
 )
 
-func NewJwtService(jwtSecret []byte, jwtLifetimeHours int) *JwtService {
-	return &JwtService{jwtSecret: jwtSecret, jwtLifetime: time.Hour * time.Duration(jwtLifetimeHours)}
+func NewJwtService(jwtSecret []byte, jwtLifetime time.Duration) *JwtService {
+	return &JwtService{
+		jwtSecret:   jwtSecret,
+		methods:     []string{jwt.SigningMethodHS256.Alg()},
+		jwtLifetime: jwtLifetime,
+	}
 }
 
-func (g *JwtService) GenerateJwtToken(userID int) (string, error) {
+// NewAsymmetricJwtService signs tokens with signingKey and verifies them with it and any of verificationKeys.
+func NewAsymmetricJwtService(signingKey *Key, verificationKeys []*Key, jwtLifetime time.Duration) (*JwtService, error) {
+	if signingKey.Private == nil {
+		return nil, fmt.Errorf("%w: signing key %s has no private part", ErrUnsupportedKey, signingKey.ID)
+	}
+
+	service := &JwtService{
+		signingKey:  signingKey,
+		keys:        map[string]*Key{signingKey.ID: signingKey},
+		jwtLifetime: jwtLifetime,
+	}
+	for _, key := range verificationKeys {
+		service.keys[key.ID] = key
+	}
+
+	seen := make(map[string]bool)
+	for _, key := range service.keys {
+		if !seen[key.Method.Alg()] {
+			seen[key.Method.Alg()] = true
+			service.methods = append(service.methods, key.Method.Alg())
+		}
+	}
+
+	return service, nil
+}
+
+// GenerateJwtToken issues an access token with a unique ID (jti), so the token can be revoked before it expires.
+func (g *JwtService) GenerateJwtToken(userID int, tokenVersion int) (string, *CustomClaims, error) {
+	jti, err := GenerateID()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
 	claims := CustomClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			// TODO: добавить в Subject login ?
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(g.jwtLifetime)),
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(g.jwtLifetime)),
 		},
-		UserID: userID,
+		UserID:       userID,
+		TokenVersion: tokenVersion,
 	}
 
-	jwtWithClaims := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	token, err := jwtWithClaims.SignedString([]byte(g.jwtSecret))
+	var token string
+	if g.signingKey != nil {
+		jwtWithClaims := jwt.NewWithClaims(g.signingKey.Method, claims)
+		jwtWithClaims.Header["kid"] = g.signingKey.ID
+		token, err = jwtWithClaims.SignedString(g.signingKey.Private)
+	} else {
+		token, err = jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(g.jwtSecret)
+	}
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return token, nil
+	return token, &claims, nil
 }
 
 func (g *JwtService) ValidateJwtToken(tokenString string) (*CustomClaims, error) {
 	claims := &CustomClaims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return g.jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, g.verificationKey, jwt.WithValidMethods(g.methods), jwt.WithExpirationRequired())
 	if err != nil {
 		return nil, err
 	}
@@ -60,3 +114,32 @@ func (g *JwtService) ValidateJwtToken(tokenString string) (*CustomClaims, error)
 
 	return claims, nil
 }
+
+// JWKS returns the public verification keys. It is empty when tokens are signed with a shared secret.
+func (g *JwtService) JWKS() []JWK {
+	jwks := make([]JWK, 0, len(g.keys))
+	for _, key := range g.keys {
+		jwks = append(jwks, key.JWK())
+	}
+
+	return jwks
+}
+
+func (g *JwtService) verificationKey(token *jwt.Token) (any, error) {
+	if g.signingKey == nil {
+		return g.jwtSecret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, ok := g.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+
+	// A key is only good for its own algorithm, whatever the token header says
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("%w: %s key used with %s", ErrInvalidToken, key.Method.Alg(), token.Method.Alg())
+	}
+
+	return key.Public, nil
+}
@@ -0,0 +1,193 @@
+package security
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeKey(t *testing.T, private crypto.Signer, public bool) string {
+	t.Helper()
+
+	var block *pem.Block
+	if public {
+		der, err := x509.MarshalPKIXPublicKey(private.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func loadKey(t *testing.T, private crypto.Signer, public bool) *Key {
+	t.Helper()
+
+	key, err := LoadKey(writeKey(t, private, public))
+	if err != nil {
+		t.Fatalf("LoadKey() error = %v", err)
+	}
+
+	return key
+}
+
+func TestAsymmetricJwtService(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		key  crypto.Signer
+		alg  string
+		kty  string
+	}{
+		{name: "RS256", key: rsaKey, alg: "RS256", kty: "RSA"},
+		{name: "ES256", key: ecKey, alg: "ES256", kty: "EC"},
+		{name: "EdDSA", key: edKey, alg: "EdDSA", kty: "OKP"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key := loadKey(t, test.key, false)
+			if key.Method.Alg() != test.alg {
+				t.Fatalf("Method = %s, want %s", key.Method.Alg(), test.alg)
+			}
+
+			service, err := NewAsymmetricJwtService(key, nil, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, _, err := service.GenerateJwtToken(42, 0)
+			if err != nil {
+				t.Fatalf("GenerateJwtToken() error = %v", err)
+			}
+
+			claims, err := service.ValidateJwtToken(token)
+			if err != nil {
+				t.Fatalf("ValidateJwtToken() error = %v", err)
+			}
+			if claims.UserID != 42 {
+				t.Errorf("UserID = %d, want 42", claims.UserID)
+			}
+
+			jwks := service.JWKS()
+			if len(jwks) != 1 || jwks[0].Kid != key.ID || jwks[0].Kty != test.kty || jwks[0].Alg != test.alg {
+				t.Errorf("JWKS() = %+v", jwks)
+			}
+		})
+	}
+}
+
+func TestJwtServiceKeyRotation(t *testing.T) {
+	oldPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, newPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldService, err := NewAsymmetricJwtService(loadKey(t, oldPrivate, false), nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, _, err := oldService.GenerateJwtToken(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// After the rotation the old key is only used to verify tokens it has already signed
+	rotated, err := NewAsymmetricJwtService(loadKey(t, newPrivate, false), []*Key{loadKey(t, oldPrivate, true)}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := rotated.ValidateJwtToken(oldToken); err != nil {
+		t.Errorf("token of the previous key: ValidateJwtToken() error = %v", err)
+	}
+
+	newToken, _, err := rotated.GenerateJwtToken(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.ValidateJwtToken(newToken); err != nil {
+		t.Errorf("token of the new key: ValidateJwtToken() error = %v", err)
+	}
+	if _, err := oldService.ValidateJwtToken(newToken); err == nil {
+		t.Error("token of the new key was accepted by a service that does not know it")
+	}
+
+	otherPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherService, err := NewAsymmetricJwtService(loadKey(t, otherPrivate, false), nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, _, err := otherService.GenerateJwtToken(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.ValidateJwtToken(otherToken); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token of an unknown key: ValidateJwtToken() error = %v, want %v", err, ErrUnknownKey)
+	}
+
+	if jwks := rotated.JWKS(); len(jwks) != 2 {
+		t.Errorf("JWKS() returned %d keys, want 2", len(jwks))
+	}
+
+	hmacToken, _, err := NewJwtService([]byte("secret"), time.Minute).GenerateJwtToken(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.ValidateJwtToken(hmacToken); err == nil {
+		t.Error("token signed with a shared secret was accepted")
+	}
+}
+
+func TestNewAsymmetricJwtServiceRequiresPrivateKey(t *testing.T) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewAsymmetricJwtService(loadKey(t, private, true), nil, time.Minute)
+	if !errors.Is(err, ErrUnsupportedKey) {
+		t.Errorf("NewAsymmetricJwtService() error = %v, want %v", err, ErrUnsupportedKey)
+	}
+}
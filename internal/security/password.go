@@ -1,13 +1,215 @@
 package security
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"sync"
+)
 
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+var (
+	ErrUnknownHashFormat = errors.New("unknown password hash format")
+)
+
+// PasswordHasher is one password hashing algorithm.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches a hash of this algorithm.
+	Verify(hash string, password string) (bool, error)
+	// Recognizes reports whether the hash was made by this algorithm, whatever the parameters.
+	Recognizes(hash string) bool
+	// NeedsRehash reports whether a hash of this algorithm was made with other parameters than the configured ones.
+	NeedsRehash(hash string) bool
+}
+
+// BcryptHasher hashes with bcrypt. Passwords longer than 72 bytes are rejected rather than truncated.
+type BcryptHasher struct {
+	cost int
+}
+
+func NewBcryptHasher(cost int) (*BcryptHasher, error) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	return &BcryptHasher{cost: cost}, nil
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
 	return string(bytes), err
 }
 
-func CheckPassword(hashedPassword string, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+func (h *BcryptHasher) Verify(hash string, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (h *BcryptHasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (h *BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.cost
+}
+
+// Argon2Params are the argon2id parameters. Memory is in KiB.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+const argon2Prefix = "$argon2id$"
+
+// Argon2idHasher hashes with argon2id and stores hashes in the PHC string format,
+// e.g. $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>, so the parameters travel with every hash.
+type Argon2idHasher struct {
+	params Argon2Params
+}
+
+func NewArgon2idHasher(params Argon2Params) (*Argon2idHasher, error) {
+	if params.Memory < 8*uint32(params.Parallelism) || params.Iterations < 1 || params.Parallelism < 1 {
+		return nil, errors.New("argon2id needs at least 1 iteration, 1 thread and 8 KiB of memory per thread")
+	}
+	if params.SaltLength < 8 || params.KeyLength < 16 {
+		return nil, errors.New("argon2id salt must be at least 8 bytes and key at least 16 bytes long")
+	}
+
+	return &Argon2idHasher{params: params}, nil
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.params.Iterations, h.params.Memory, h.params.Parallelism, h.params.KeyLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2Prefix, argon2.Version, h.params.Memory, h.params.Iterations, h.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h *Argon2idHasher) Verify(hash string, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *Argon2idHasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, argon2Prefix)
+}
+
+func (h *Argon2idHasher) NeedsRehash(hash string) bool {
+	params, salt, _, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != h.params.Memory || params.Iterations != h.params.Iterations ||
+		params.Parallelism != h.params.Parallelism || params.KeyLength != h.params.KeyLength ||
+		uint32(len(salt)) != h.params.SaltLength
+}
+
+func decodeArgon2Hash(hash string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("%w: argon2 version %q", ErrUnknownHashFormat, parts[2])
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("%w: argon2 parameters %q", ErrUnknownHashFormat, parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w: argon2 salt", ErrUnknownHashFormat)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("%w: argon2 key", ErrUnknownHashFormat)
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+// Passwords hashes new passwords with the current hasher and verifies stored hashes with whichever
+// of the known hashers made them, so the algorithm or its cost can be changed without resetting passwords.
+type Passwords struct {
+	current   PasswordHasher
+	hashers   []PasswordHasher
+	dummyHash func() string
+}
+
+// NewPasswords hashes with current and also verifies hashes of legacy hashers.
+func NewPasswords(current PasswordHasher, legacy ...PasswordHasher) *Passwords {
+	passwords := &Passwords{current: current, hashers: append([]PasswordHasher{current}, legacy...)}
+
+	// The dummy hash is generated on first use, so tests and tools do not pay for it
+	passwords.dummyHash = sync.OnceValue(func() string {
+		hash, _ := current.Hash("gophermart-dummy-password")
+		return hash
+	})
+
+	return passwords
+}
+
+func (p *Passwords) Hash(password string) (string, error) {
+	return p.current.Hash(password)
+}
+
+// Verify reports whether the password matches the stored hash and, if it does, whether the hash
+// should be replaced with a fresh one of the current hasher.
+func (p *Passwords) Verify(hash string, password string) (ok bool, rehash bool, err error) {
+	for _, hasher := range p.hashers {
+		if !hasher.Recognizes(hash) {
+			continue
+		}
+
+		ok, err = hasher.Verify(hash, password)
+		if err != nil || !ok {
+			return false, false, err
+		}
+
+		return true, hasher != p.current || p.current.NeedsRehash(hash), nil
+	}
+
+	return false, false, ErrUnknownHashFormat
+}
+
+// VerifyDummy takes as long as Verify against a hash of the current hasher and always fails.
+// It is used for unknown logins, so the response time does not reveal which accounts exist.
+func (p *Passwords) VerifyDummy(password string) bool {
+	_, _ = p.current.Verify(p.dummyHash(), password)
+	return false
 }
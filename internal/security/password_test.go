@@ -0,0 +1,114 @@
+package security
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+func testArgon2Params(iterations uint32) Argon2Params {
+	return Argon2Params{Memory: 64, Iterations: iterations, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+}
+
+func TestPasswordHashers(t *testing.T) {
+	bcryptHasher, err := NewBcryptHasher(bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2Hasher, err := NewArgon2idHasher(testArgon2Params(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hasher := range []PasswordHasher{bcryptHasher, argon2Hasher} {
+		hash, err := hasher.Hash("correct-horse")
+		if err != nil {
+			t.Fatalf("%T.Hash() error = %v", hasher, err)
+		}
+		if len(hash) > 255 {
+			t.Errorf("%T.Hash() is %d characters long, does not fit into users.password", hasher, len(hash))
+		}
+		if !hasher.Recognizes(hash) || hasher.NeedsRehash(hash) {
+			t.Errorf("%T does not accept its own hash %q", hasher, hash)
+		}
+
+		if ok, err := hasher.Verify(hash, "correct-horse"); !ok || err != nil {
+			t.Errorf("%T.Verify() = %v, %v, want true", hasher, ok, err)
+		}
+		if ok, err := hasher.Verify(hash, "wrong-horse"); ok || err != nil {
+			t.Errorf("%T.Verify() with wrong password = %v, %v, want false", hasher, ok, err)
+		}
+	}
+
+	argon2Hash, err := argon2Hasher.Hash("correct-horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(argon2Hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("argon2id hash = %q, want PHC format", argon2Hash)
+	}
+	if bcryptHasher.Recognizes(argon2Hash) {
+		t.Error("bcrypt recognizes argon2id hash")
+	}
+
+	if _, err := bcryptHasher.Hash(strings.Repeat("a", 73)); err == nil {
+		t.Error("bcrypt hashed a password over 72 bytes")
+	}
+}
+
+func TestPasswordsRehash(t *testing.T) {
+	bcryptHasher, err := NewBcryptHasher(bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strongerBcrypt, err := NewBcryptHasher(bcrypt.MinCost + 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2Hasher, err := NewArgon2idHasher(testArgon2Params(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	strongerArgon2, err := NewArgon2idHasher(testArgon2Params(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bcryptHash, _ := bcryptHasher.Hash("correct-horse")
+	argon2Hash, _ := argon2Hasher.Hash("correct-horse")
+
+	tests := []struct {
+		name       string
+		passwords  *Passwords
+		hash       string
+		password   string
+		wantOK     bool
+		wantRehash bool
+		wantErr    error
+	}{
+		{name: "Current", passwords: NewPasswords(argon2Hasher, bcryptHasher), hash: argon2Hash, password: "correct-horse", wantOK: true},
+		{name: "Legacy algorithm", passwords: NewPasswords(argon2Hasher, bcryptHasher), hash: bcryptHash, password: "correct-horse", wantOK: true, wantRehash: true},
+		{name: "Outdated bcrypt cost", passwords: NewPasswords(strongerBcrypt), hash: bcryptHash, password: "correct-horse", wantOK: true, wantRehash: true},
+		{name: "Outdated argon2id parameters", passwords: NewPasswords(strongerArgon2), hash: argon2Hash, password: "correct-horse", wantOK: true, wantRehash: true},
+		{name: "Wrong password of legacy hash", passwords: NewPasswords(argon2Hasher, bcryptHasher), hash: bcryptHash, password: "wrong-horse"},
+		{name: "Unknown algorithm", passwords: NewPasswords(argon2Hasher), hash: bcryptHash, password: "correct-horse", wantErr: ErrUnknownHashFormat},
+		{name: "Malformed argon2id hash", passwords: NewPasswords(argon2Hasher), hash: "$argon2id$v=19$m=64$salt$key", password: "correct-horse", wantErr: ErrUnknownHashFormat},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ok, rehash, err := test.passwords.Verify(test.hash, test.password)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, test.wantErr)
+			}
+			if ok != test.wantOK || rehash != test.wantRehash {
+				t.Errorf("Verify() = %v, %v, want %v, %v", ok, rehash, test.wantOK, test.wantRehash)
+			}
+		})
+	}
+
+	if NewPasswords(argon2Hasher).VerifyDummy("correct-horse") {
+		t.Error("VerifyDummy() = true")
+	}
+}
@@ -0,0 +1,61 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const signaturePrefix = "sha256="
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrSignatureExpired = errors.New("signature timestamp outside of tolerance")
+)
+
+// SignatureVerifier checks HMAC-SHA256 signatures of inbound callbacks.
+// The signed message is "<unix timestamp>.<body>", which binds the body to the moment it was sent
+// and lets stale requests be rejected as replays.
+type SignatureVerifier struct {
+	secret    []byte
+	tolerance time.Duration
+}
+
+func NewSignatureVerifier(secret []byte, tolerance time.Duration) *SignatureVerifier {
+	return &SignatureVerifier{secret: secret, tolerance: tolerance}
+}
+
+func (v *SignatureVerifier) Sign(body []byte, timestamp string) string {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (v *SignatureVerifier) Verify(body []byte, timestamp string, signature string) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	age := time.Since(time.Unix(seconds, 0))
+	if age > v.tolerance || age < -v.tolerance {
+		return ErrSignatureExpired
+	}
+
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(v.Sign(body, timestamp)), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+package security
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignatureVerifier(t *testing.T) {
+	verifier := NewSignatureVerifier([]byte("secret"), time.Minute)
+	body := []byte(`{"order":"12345678903","status":"PROCESSED","accrual":500}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		body      []byte
+		timestamp string
+		signature string
+		wantErr   error
+	}{
+		{name: "Valid", body: body, timestamp: now, signature: verifier.Sign(body, now)},
+		{name: "Tampered body", body: []byte(`{}`), timestamp: now, signature: verifier.Sign(body, now), wantErr: ErrInvalidSignature},
+		{name: "Other secret", body: body, timestamp: now, signature: NewSignatureVerifier([]byte("other"), time.Minute).Sign(body, now), wantErr: ErrInvalidSignature},
+		{name: "Missing prefix", body: body, timestamp: now, signature: verifier.Sign(body, now)[len(signaturePrefix):], wantErr: ErrInvalidSignature},
+		{name: "Expired", body: body, timestamp: old, signature: verifier.Sign(body, old), wantErr: ErrSignatureExpired},
+		{name: "Bad timestamp", body: body, timestamp: "yesterday", signature: verifier.Sign(body, "yesterday"), wantErr: ErrInvalidSignature},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := verifier.Verify(test.body, test.timestamp, test.signature); !errors.Is(err, test.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -1,23 +1,63 @@
 package service
 
 import (
+	"context"
+	"database/sql"
 	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
 	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"go.uber.org/zap"
 )
 
 type BalanceService struct {
-	balanceRepository *repository.BalanceRepository
+	ledgerRepository *repository.LedgerRepository
 }
 
-func NewBalanceService(balanceRepository *repository.BalanceRepository) *BalanceService {
-	return &BalanceService{balanceRepository: balanceRepository}
+func NewBalanceService(ledgerRepository *repository.LedgerRepository) *BalanceService {
+	return &BalanceService{ledgerRepository: ledgerRepository}
 }
 
-func (s *BalanceService) GetBalance(userID int) (*model.Balance, error) {
-	balance, err := s.balanceRepository.GetBalanceByUserID(userID)
+// GetBalance returns the balance derived from the ledger. It neither locks nor writes anything:
+// the balances row is reconciled on withdrawal, when it is locked anyway.
+func (s *BalanceService) GetBalance(ctx context.Context, userID int) (*model.Balance, error) {
+	return s.ledgerRepository.ReadBalanceByUserID(ctx, userID)
+}
+
+// reconcileBalance locks the balances row of the user and rebuilds it from the ledger if the two have diverged.
+// It returns the ledger-derived balance.
+func reconcileBalance(
+	ctx context.Context,
+	tx *sql.Tx,
+	balanceRepository *repository.BalanceRepository,
+	ledgerRepository *repository.LedgerRepository,
+	userID int,
+) (*model.Balance, error) {
+	projection, err := balanceRepository.GetBalanceForUpdateByUserID(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := ledgerRepository.GetBalanceByUserID(ctx, tx, userID)
 	if err != nil {
 		return nil, err
 	}
 
+	balance.ID = projection.ID
+
+	if projection.Current != balance.Current || projection.Withdrawn != balance.Withdrawn {
+		zap.L().Warn(
+			"Balance diverged from ledger, rebuilding",
+			zap.Int("userID", userID),
+			zap.Stringer("current", projection.Current),
+			zap.Stringer("withdrawn", projection.Withdrawn),
+			zap.Stringer("ledgerCurrent", balance.Current),
+			zap.Stringer("ledgerWithdrawn", balance.Withdrawn),
+		)
+
+		err = balanceRepository.SetByUserID(ctx, tx, userID, balance.Current, balance.Withdrawn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return balance, nil
 }
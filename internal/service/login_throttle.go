@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/backoff"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/stringutils"
+	"go.uber.org/zap"
+	"time"
+)
+
+var (
+	ErrTooManyLoginAttempts = errors.New("too many login attempts")
+)
+
+// LoginThrottledError is returned while logins for the login or the address are blocked.
+// It matches ErrTooManyLoginAttempts.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrTooManyLoginAttempts, e.RetryAfter)
+}
+
+func (e *LoginThrottledError) Is(target error) bool {
+	return target == ErrTooManyLoginAttempts
+}
+
+type LoginThrottleOptions struct {
+	// FreeAttempts is how many failures in a row are allowed without a delay.
+	FreeAttempts int
+	// After that every further failure for a login blocks its next attempt for an exponentially growing delay.
+	// Addresses get no delay, since many users may share one.
+	DelayBase time.Duration
+	DelayMax  time.Duration
+	// LoginLockoutAttempts failures for one login, or IPLockoutAttempts failures from one address,
+	// lock it out for LockoutDuration. Failures older than LockoutDuration are forgotten.
+	LoginLockoutAttempts int
+	IPLockoutAttempts    int
+	LockoutDuration      time.Duration
+	// LoginKind and IPKind are the kinds of counters the throttle keeps, LoginAttemptByLogin and LoginAttemptByIP
+	// by default. A throttle of other requests, like password resets, uses kinds of its own.
+	LoginKind model.LoginAttemptKind
+	IPKind    model.LoginAttemptKind
+}
+
+// LoginThrottle counts failed logins per login and per client address. The counters are kept in the database,
+// so the limits hold across all instances of the service.
+type LoginThrottle struct {
+	transactionManager     *db.TransactionManager
+	loginAttemptRepository *repository.LoginAttemptRepository
+	options                LoginThrottleOptions
+}
+
+func NewLoginThrottle(
+	transactionManager *db.TransactionManager,
+	loginAttemptRepository *repository.LoginAttemptRepository,
+	options LoginThrottleOptions,
+) *LoginThrottle {
+	if options.LoginKind == "" {
+		options.LoginKind = model.LoginAttemptByLogin
+	}
+	if options.IPKind == "" {
+		options.IPKind = model.LoginAttemptByIP
+	}
+
+	return &LoginThrottle{
+		transactionManager:     transactionManager,
+		loginAttemptRepository: loginAttemptRepository,
+		options:                options,
+	}
+}
+
+// Reserve counts an attempt as a failed one for every key before the password is checked, and blocks the keys
+// that exceeded their limits. The counters stay locked until the attempt is counted, so concurrent guesses
+// see each other and can not slip past the delay. If any of the keys is blocked, nothing is counted and
+// LoginThrottledError is returned, so blocked attempts cost no bcrypt time.
+// An attempt that turns out to be successful is given back by RecordSuccess.
+func (t *LoginThrottle) Reserve(ctx context.Context, keys []model.LoginAttemptKey) error {
+	_, err := t.transactionManager.RunInTransaction(ctx, func(tx *sql.Tx) (any, error) {
+		failures := make([]int, len(keys))
+		var blockedUntil *time.Time
+		var err error
+		for i, key := range keys {
+			var keyBlockedUntil *time.Time
+			failures[i], keyBlockedUntil, err = t.loginAttemptRepository.RecordFailure(ctx, tx, key, t.options.LockoutDuration)
+			if err != nil {
+				return nil, err
+			}
+
+			if keyBlockedUntil != nil && keyBlockedUntil.After(time.Now()) &&
+				(blockedUntil == nil || keyBlockedUntil.After(*blockedUntil)) {
+				blockedUntil = keyBlockedUntil
+			}
+		}
+
+		if blockedUntil != nil {
+			// Rolls back the counting
+			return nil, &LoginThrottledError{RetryAfter: time.Until(*blockedUntil)}
+		}
+
+		for i, key := range keys {
+			delay, lockout := t.delay(key.Kind, failures[i])
+			if delay <= 0 {
+				continue
+			}
+
+			if lockout {
+				zap.L().Warn("Login locked out", zap.String("kind", string(key.Kind)), zap.String("key", key.Key), zap.Int("failures", failures[i]))
+			}
+
+			err = t.loginAttemptRepository.Block(ctx, tx, key, time.Now().Add(delay))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	})
+
+	return err
+}
+
+// RecordSuccess gives back an attempt reserved for the keys that turned out to be successful. The failures
+// of the login are forgotten. The address only gets its attempt back: resetting it would let an attacker
+// clear its counter by logging in to an account of its own.
+func (t *LoginThrottle) RecordSuccess(ctx context.Context, keys []model.LoginAttemptKey) error {
+	for _, key := range keys {
+		var err error
+		if key.Kind == t.options.IPKind {
+			err = t.loginAttemptRepository.ForgetFailure(ctx, key)
+		} else {
+			err = t.loginAttemptRepository.Reset(ctx, key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PruneExpired removes counters whose failures have been forgotten and whose blocks have run out.
+// It returns the number of counters removed.
+func (t *LoginThrottle) PruneExpired(ctx context.Context) (int64, error) {
+	return t.loginAttemptRepository.DeleteExpired(ctx, []model.LoginAttemptKind{t.options.LoginKind, t.options.IPKind}, t.options.LockoutDuration)
+}
+
+// keys returns the counters of the throttle an attempt to access login from clientIP is counted by.
+// The address is left out if it is empty.
+func (t *LoginThrottle) keys(login string, clientIP string) []model.LoginAttemptKey {
+	keys := []model.LoginAttemptKey{{Kind: t.options.LoginKind, Key: login}}
+	if !stringutils.IsEmpty(clientIP) {
+		keys = append(keys, model.LoginAttemptKey{Kind: t.options.IPKind, Key: clientIP})
+	}
+
+	return keys
+}
+
+// delay returns how long the key is blocked after its failures-th failure and whether that is a lockout.
+func (t *LoginThrottle) delay(kind model.LoginAttemptKind, failures int) (time.Duration, bool) {
+	lockoutAttempts := t.options.LoginLockoutAttempts
+	if kind == t.options.IPKind {
+		lockoutAttempts = t.options.IPLockoutAttempts
+	}
+
+	if lockoutAttempts > 0 && failures >= lockoutAttempts {
+		return t.options.LockoutDuration, true
+	}
+
+	if kind == t.options.IPKind || failures <= t.options.FreeAttempts {
+		return 0, false
+	}
+
+	return backoff.Exponential(failures-t.options.FreeAttempts-1, t.options.DelayBase, t.options.DelayMax), false
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestLoginThrottle(database *sql.DB, options LoginThrottleOptions) *LoginThrottle {
+	return NewLoginThrottle(db.NewTransactionManager(database), repository.NewLoginAttemptRepository(database), options)
+}
+
+func loginKeys(login string) []model.LoginAttemptKey {
+	return []model.LoginAttemptKey{{Kind: model.LoginAttemptByLogin, Key: login}}
+}
+
+func TestReserveBlocksConcurrentAttempts(t *testing.T) {
+	throttle := newTestLoginThrottle(openTestDB(t), LoginThrottleOptions{
+		FreeAttempts:    1,
+		DelayBase:       time.Minute,
+		DelayMax:        time.Minute,
+		LockoutDuration: time.Hour,
+	})
+	keys := loginKeys("login" + unique())
+
+	const attempts = 10
+	errs := make(chan error, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- throttle.Reserve(context.Background(), keys)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	// One free attempt and the one that set the delay get through, every other one sees the delay
+	reserved := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			reserved++
+		case !errors.Is(err, ErrTooManyLoginAttempts):
+			t.Fatalf("Reserve() error = %v", err)
+		}
+	}
+	if reserved != 2 {
+		t.Errorf("Reserve() let %d concurrent attempts through, want 2", reserved)
+	}
+}
+
+func TestRecordSuccessGivesAttemptBack(t *testing.T) {
+	throttle := newTestLoginThrottle(openTestDB(t), LoginThrottleOptions{
+		FreeAttempts:      1,
+		DelayBase:         time.Minute,
+		DelayMax:          time.Minute,
+		IPLockoutAttempts: 2,
+		LockoutDuration:   time.Hour,
+	})
+	ip := model.LoginAttemptKey{Kind: model.LoginAttemptByIP, Key: "ip" + unique()}
+	login := loginKeys("login" + unique())[0]
+	keys := []model.LoginAttemptKey{login, ip}
+
+	for i := 0; i < 3; i++ {
+		if err := throttle.Reserve(context.Background(), keys); err != nil {
+			t.Fatalf("Reserve() #%d error = %v", i+1, err)
+		}
+		if err := throttle.RecordSuccess(context.Background(), keys); err != nil {
+			t.Fatalf("RecordSuccess() error = %v", err)
+		}
+	}
+
+	// Successful logins do not count, so the address is locked out by the second failure only
+	if err := throttle.Reserve(context.Background(), []model.LoginAttemptKey{ip}); err != nil {
+		t.Fatalf("Reserve() of the first failure error = %v", err)
+	}
+	if err := throttle.Reserve(context.Background(), []model.LoginAttemptKey{ip}); err != nil {
+		t.Fatalf("Reserve() of the second failure error = %v", err)
+	}
+	if err := throttle.Reserve(context.Background(), keys); !errors.Is(err, ErrTooManyLoginAttempts) {
+		t.Errorf("Reserve() after the lockout error = %v, want %v", err, ErrTooManyLoginAttempts)
+	}
+}
+
+func TestReserveAcceptsLongLogin(t *testing.T) {
+	throttle := newTestLoginThrottle(openTestDB(t), LoginThrottleOptions{LockoutDuration: time.Hour})
+	keys := loginKeys(strings.Repeat("x", 1000) + unique())
+
+	if err := throttle.Reserve(context.Background(), keys); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	if err := throttle.RecordSuccess(context.Background(), keys); err != nil {
+		t.Fatalf("RecordSuccess() error = %v", err)
+	}
+}
+
+func TestPruneExpiredLoginAttempts(t *testing.T) {
+	throttle := newTestLoginThrottle(openTestDB(t), LoginThrottleOptions{FreeAttempts: 10, LockoutDuration: 50 * time.Millisecond})
+
+	if err := throttle.Reserve(context.Background(), loginKeys("login"+unique())); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	removed, err := throttle.PruneExpired(context.Background())
+	if err != nil {
+		t.Fatalf("PruneExpired() error = %v", err)
+	}
+	if removed < 1 {
+		t.Errorf("PruneExpired() removed %d counters, want at least 1", removed)
+	}
+}
+
+func TestResetThrottleLeavesLoginsAlone(t *testing.T) {
+	database := openTestDB(t)
+	loginThrottle := newTestLoginThrottle(database, LoginThrottleOptions{
+		FreeAttempts:         1,
+		DelayBase:            time.Minute,
+		DelayMax:             time.Minute,
+		LoginLockoutAttempts: 2,
+		LockoutDuration:      time.Hour,
+	})
+	resetThrottle := newTestLoginThrottle(database, LoginThrottleOptions{
+		FreeAttempts:         2,
+		LoginLockoutAttempts: 2,
+		LockoutDuration:      time.Hour,
+		LoginKind:            model.ResetRequestByLogin,
+		IPKind:               model.ResetRequestByIP,
+	})
+	login := "login" + unique()
+
+	for i := 0; i < 2; i++ {
+		if err := resetThrottle.Reserve(context.Background(), resetThrottle.keys(login, "")); err != nil {
+			t.Fatalf("Reserve() of reset request #%d error = %v", i+1, err)
+		}
+	}
+	if err := resetThrottle.Reserve(context.Background(), resetThrottle.keys(login, "")); !errors.Is(err, ErrTooManyLoginAttempts) {
+		t.Errorf("Reserve() of a reset request over the limit error = %v, want %v", err, ErrTooManyLoginAttempts)
+	}
+
+	if err := loginThrottle.Reserve(context.Background(), loginThrottle.keys(login, "")); err != nil {
+		t.Errorf("Reserve() of a login after reset requests error = %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/stringutils"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The service tests need PostgreSQL. They run against TEST_DATABASE_URI and are skipped when it is not set.
+const testDatabaseURIEnv = "TEST_DATABASE_URI"
+
+var (
+	migrateOnce sync.Once
+	migrateErr  error
+	uniqueSeq   atomic.Int64
+)
+
+func TestMain(m *testing.M) {
+	// Migrations are read from db/migrations relative to the repository root
+	if err := os.Chdir("../.."); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	os.Exit(m.Run())
+}
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	uri := os.Getenv(testDatabaseURIEnv)
+	if stringutils.IsEmpty(uri) {
+		t.Skipf("%s is not set", testDatabaseURIEnv)
+	}
+
+	database, err := db.NewDBStorage(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = database.Close() })
+
+	migrateOnce.Do(func() { migrateErr = db.RunMigrations(database) })
+	if migrateErr != nil {
+		t.Fatal(migrateErr)
+	}
+
+	return database
+}
+
+// unique returns a value no other test run has used, for logins and order numbers in a shared database.
+func unique() string {
+	return fmt.Sprintf("%d%d", time.Now().UnixNano(), uniqueSeq.Add(1))
+}
+
+func createTestUser(t *testing.T, database *sql.DB) int {
+	t.Helper()
+
+	userID, err := db.NewTransactionManager(database).RunInTransaction(context.Background(), func(tx *sql.Tx) (any, error) {
+		user, err := repository.NewUserRepository(database).CreateUser(context.Background(), tx, "user"+unique(), "hash")
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = repository.NewBalanceRepository(database).CreateBalance(context.Background(), tx, user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return user.ID, nil
+	})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	return userID.(int)
+}
@@ -1,11 +1,16 @@
 package service
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"github.com/zavtra-na-rabotu/gophermart/internal/db"
 	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
 	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/cursor"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/luhn"
+	"go.uber.org/zap"
+	"time"
 )
 
 var (
@@ -16,24 +21,30 @@ type OrderService struct {
 	transactionManager *db.TransactionManager
 	orderRepository    *repository.OrderRepository
 	balanceRepository  *repository.BalanceRepository
+	ledgerRepository   *repository.LedgerRepository
+	eventRepository    *repository.AccrualEventRepository
 }
 
 func NewOrderService(
 	transactionManager *db.TransactionManager,
 	orderRepository *repository.OrderRepository,
 	balanceRepository *repository.BalanceRepository,
+	ledgerRepository *repository.LedgerRepository,
+	eventRepository *repository.AccrualEventRepository,
 ) *OrderService {
 	return &OrderService{
 		transactionManager: transactionManager,
 		orderRepository:    orderRepository,
 		balanceRepository:  balanceRepository,
+		ledgerRepository:   ledgerRepository,
+		eventRepository:    eventRepository,
 	}
 }
 
-func (s *OrderService) CreateOrder(orderNumber string, userID int) error {
+func (s *OrderService) CreateOrder(ctx context.Context, orderNumber string, userID int) error {
 	var finalError error
 
-	err := s.orderRepository.CreateOrder(orderNumber, userID)
+	err := s.orderRepository.CreateOrder(ctx, orderNumber, userID)
 	if err != nil {
 		if errors.Is(err, repository.ErrOrderAlreadyExists) {
 			finalError = err
@@ -42,7 +53,7 @@ func (s *OrderService) CreateOrder(orderNumber string, userID int) error {
 		}
 	}
 
-	order, err := s.orderRepository.GetOrder(orderNumber)
+	order, err := s.orderRepository.GetOrder(ctx, orderNumber)
 	if err != nil {
 		return err
 	}
@@ -58,37 +69,336 @@ func (s *OrderService) CreateOrder(orderNumber string, userID int) error {
 	return nil
 }
 
-func (s *OrderService) UpdateOrder(orderNumber string, accrual float64, status string) error {
-	_, err := s.transactionManager.RunInTransaction(func(tx *sql.Tx) (any, error) {
-		order, err := s.orderRepository.UpdateOrderByNumber(tx, accrual, status, orderNumber)
+// CreateOrders uploads a batch of order numbers for the user in one transaction and reports the result for each
+// number, in the order given. Numbers failing the Luhn check are reported as invalid and not inserted.
+// A number repeated in the batch is uploaded once, its later occurrences are reported as already uploaded.
+func (s *OrderService) CreateOrders(ctx context.Context, orderNumbers []string, userID int) ([]model.OrderUpload, error) {
+	var valid []string
+	seen := make(map[string]bool, len(orderNumbers))
+	for _, orderNumber := range orderNumbers {
+		if luhn.Valid(orderNumber) && !seen[orderNumber] {
+			valid = append(valid, orderNumber)
+			seen[orderNumber] = true
+		}
+	}
+
+	results := make(map[string]model.OrderUploadResult, len(orderNumbers))
+	if len(valid) > 0 {
+		_, err := s.transactionManager.RunInTransaction(ctx, func(tx *sql.Tx) (any, error) {
+			created, err := s.orderRepository.CreateOrdersInTransaction(ctx, tx, valid, userID)
+			if err != nil {
+				return nil, err
+			}
+
+			owners, err := s.orderRepository.GetOrderOwners(ctx, tx, valid)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, orderNumber := range valid {
+				if owners[orderNumber] == userID {
+					results[orderNumber] = model.UploadAlreadyUploaded
+				} else {
+					results[orderNumber] = model.UploadOwnedByAnother
+				}
+			}
+			for _, orderNumber := range created {
+				results[orderNumber] = model.UploadAccepted
+			}
+
+			return nil, nil
+		})
 		if err != nil {
 			return nil, err
 		}
+	}
+
+	uploads := make([]model.OrderUpload, len(orderNumbers))
+	for i, orderNumber := range orderNumbers {
+		result, ok := results[orderNumber]
+		if !ok {
+			result = model.UploadInvalid
+		}
+		if result == model.UploadAccepted {
+			results[orderNumber] = model.UploadAlreadyUploaded
+		}
+		uploads[i] = model.OrderUpload{Number: orderNumber, Result: result}
+	}
+
+	return uploads, nil
+}
+
+// RecordAccrualEvents stores accrual system responses before they are applied, so a result is never lost
+// once it has been received. Events without a status to apply, like REGISTERED, are stored as already applied.
+func (s *OrderService) RecordAccrualEvents(ctx context.Context, events []model.AccrualEvent) ([]model.AccrualEvent, error) {
+	recorded, err := s.transactionManager.RunInTransaction(ctx, func(tx *sql.Tx) (any, error) {
+		recorded := make([]model.AccrualEvent, 0, len(events))
+		for _, event := range events {
+			if !isOrderStatus(event.Status) {
+				outcome := model.EventIgnored
+				event.Outcome = &outcome
+			}
+
+			created, err := s.eventRepository.CreateEvent(ctx, tx, &event)
+			if err != nil {
+				return nil, err
+			}
+
+			recorded = append(recorded, *created)
+		}
+
+		return recorded, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return recorded.([]model.AccrualEvent), nil
+}
 
-		err = s.balanceRepository.AccrueByUserID(tx, order.UserID, accrual)
+// ReceiveAccrualEvent records a single event and applies it right away. It returns the error the order
+// rejected the event with, like UpdateOrder; if applying fails the event stays recorded and is replayed later.
+func (s *OrderService) ReceiveAccrualEvent(ctx context.Context, event model.AccrualEvent) error {
+	recorded, err := s.RecordAccrualEvents(ctx, []model.AccrualEvent{event})
+	if err != nil {
+		return err
+	}
+
+	if recorded[0].Outcome != nil {
+		return nil
+	}
+
+	rejected, err := s.ApplyAccrualEvents(ctx, []int64{recorded[0].ID}, "")
+	if err != nil {
+		return err
+	}
+
+	return rejected[event.OrderNumber]
+}
+
+// ApplyAccrualEvents applies recorded events to their orders and marks them applied in the same transaction.
+// Events already applied, or being applied by someone else, are skipped and do not appear in the result.
+// Updates rejected by the order state machine do not abort the batch; they are returned keyed by order number,
+// and for an order with several events the last one counts. Events polled under a lease pass the polling
+// instance as claimedBy, and are rejected for orders that have been claimed by another instance since.
+func (s *OrderService) ApplyAccrualEvents(ctx context.Context, ids []int64, claimedBy string) (map[string]error, error) {
+	rejected, err := s.transactionManager.RunInTransaction(ctx, func(tx *sql.Tx) (any, error) {
+		events, err := s.eventRepository.LockUnappliedByID(ctx, tx, ids)
 		if err != nil {
 			return nil, err
 		}
 
-		return nil, nil
+		return s.applyAccrualEvents(ctx, tx, events, claimedBy)
 	})
-	return err
+	if err != nil {
+		return nil, err
+	}
+
+	return rejected.(map[string]error), nil
+}
+
+// ReplayAccrualEvents applies up to limit events that were recorded more than olderThan ago but never applied,
+// for example because the process crashed in between. It returns the number of events replayed.
+func (s *OrderService) ReplayAccrualEvents(ctx context.Context, olderThan time.Duration, limit int) (int, error) {
+	replayed, err := s.transactionManager.RunInTransaction(ctx, func(tx *sql.Tx) (any, error) {
+		events, err := s.eventRepository.LockUnapplied(ctx, tx, olderThan, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		// The lease the events were polled under may be gone already, the state machine still guards the order
+		_, err = s.applyAccrualEvents(ctx, tx, events, "")
+		if err != nil {
+			return nil, err
+		}
+
+		return len(events), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return replayed.(int), nil
+}
+
+// PruneAccrualEvents removes events older than retention that did not change their order.
+// It returns the number of events removed.
+func (s *OrderService) PruneAccrualEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	return s.eventRepository.DeleteNoise(ctx, retention)
+}
+
+func (s *OrderService) applyAccrualEvents(ctx context.Context, tx *sql.Tx, events []model.AccrualEvent, claimedBy string) (map[string]error, error) {
+	if len(events) == 0 {
+		return map[string]error{}, nil
+	}
+
+	// Events of the same order are applied in sequence, each one against the status left by the previous one
+	updates := make([]model.OrderUpdate, 0, len(events))
+	for _, event := range events {
+		if isOrderStatus(event.Status) {
+			updates = append(updates, model.OrderUpdate{
+				Number:  event.OrderNumber,
+				Status:  model.OrderStatus(event.Status),
+				Accrual: event.Accrual,
+			})
+		}
+	}
+
+	var updateErrs []error
+	if len(updates) > 0 {
+		var err error
+		updateErrs, err = s.updateOrders(ctx, tx, updates, claimedBy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rejected := map[string]error{}
+	ids := make([]int64, len(events))
+	outcomes := make([]string, len(events))
+	errs := make([]*string, len(events))
+	update := 0
+	for i, event := range events {
+		ids[i] = event.ID
+
+		if !isOrderStatus(event.Status) {
+			outcomes[i] = string(model.EventIgnored)
+			continue
+		}
+
+		err := updateErrs[update]
+		update++
+
+		delete(rejected, event.OrderNumber)
+		switch {
+		case err == nil:
+			outcomes[i] = string(model.EventApplied)
+		case errors.Is(err, repository.ErrOrderStatusUnchanged):
+			outcomes[i] = string(model.EventUnchanged)
+			rejected[event.OrderNumber] = err
+		default:
+			message := err.Error()
+			outcomes[i] = string(model.EventRejected)
+			errs[i] = &message
+			rejected[event.OrderNumber] = err
+		}
+	}
+
+	err := s.eventRepository.MarkApplied(ctx, tx, ids, outcomes, errs)
+	if err != nil {
+		return nil, err
+	}
+
+	return rejected, nil
 }
 
-func (s *OrderService) GetOrders(userID int) ([]model.Order, error) {
-	orders, err := s.orderRepository.GetOrders(userID)
+// updateOrders applies status updates and credits the balance of orders that moved into PROCESSED,
+// so repeated updates never credit twice.
+func (s *OrderService) updateOrders(ctx context.Context, tx *sql.Tx, updates []model.OrderUpdate, claimedBy string) ([]error, error) {
+	orders, rejected, err := s.orderRepository.UpdateOrdersByNumber(ctx, tx, updates, claimedBy)
 	if err != nil {
 		return nil, err
 	}
 
-	return orders, nil
+	for _, order := range orders {
+		err = s.creditAccrual(ctx, tx, &order)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i, err := range rejected {
+		if errors.Is(err, repository.ErrIllegalStatusTransition) {
+			zap.L().Warn("Rejected order status transition", zap.String("order", updates[i].Number), zap.Error(err))
+		}
+	}
+
+	return rejected, nil
+}
+
+// isOrderStatus reports whether an accrual system status is also an order status, i.e. has to be applied.
+func isOrderStatus(status string) bool {
+	switch model.OrderStatus(status) {
+	case model.Processing, model.Invalid, model.Processed:
+		return true
+	default:
+		return false
+	}
 }
 
-func (s *OrderService) GetAllNotTerminated() ([]model.Order, error) {
-	orders, err := s.orderRepository.GetAllNotTerminated()
+func (s *OrderService) creditAccrual(ctx context.Context, tx *sql.Tx, order *model.Order) error {
+	if order.Status != model.Processed || order.Accrual == 0 {
+		return nil
+	}
+
+	_, err := s.ledgerRepository.CreateEntry(ctx, tx, &model.LedgerEntry{
+		UserID:      order.UserID,
+		Type:        model.AccrualEntry,
+		Amount:      order.Accrual,
+		OrderNumber: &order.Number,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.balanceRepository.AccrueByUserID(ctx, tx, order.UserID, order.Accrual)
+}
+
+func (s *OrderService) GetOrders(ctx context.Context, userID int, query model.OrderQuery) ([]model.Order, *cursor.Cursor, error) {
+	orders, next, err := s.orderRepository.GetOrders(ctx, userID, query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return orders, next, nil
+}
+
+// GetOrder returns an order of the user together with its status history.
+// Orders of other users are reported as not found, exactly like unknown ones.
+func (s *OrderService) GetOrder(ctx context.Context, orderNumber string, userID int) (*model.Order, []model.OrderStatusChange, error) {
+	order, err := s.orderRepository.GetUserOrder(ctx, orderNumber, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	history, err := s.orderRepository.GetStatusHistory(ctx, order.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return order, history, nil
+}
+
+func (s *OrderService) ClaimOrders(ctx context.Context, claimedBy string, limit int, lease time.Duration) ([]model.Order, error) {
+	orders, err := s.orderRepository.ClaimNotTerminated(ctx, claimedBy, limit, lease)
 	if err != nil {
 		return nil, err
 	}
 
 	return orders, nil
 }
+
+// MarkOrderStale gives up on an order the accrual system has not reported on within the polling limits.
+// STALE is terminal, so the order is not polled any more.
+func (s *OrderService) MarkOrderStale(ctx context.Context, orderNumber string, claimedBy string) error {
+	rejected, err := s.transactionManager.RunInTransaction(ctx, func(tx *sql.Tx) (any, error) {
+		return s.updateOrders(ctx, tx, []model.OrderUpdate{{Number: orderNumber, Status: model.Stale}}, claimedBy)
+	})
+	if err != nil {
+		return err
+	}
+
+	return rejected.([]error)[0]
+}
+
+func (s *OrderService) ScheduleNextPoll(ctx context.Context, orderNumber string, claimedBy string, delay time.Duration) error {
+	return s.orderRepository.ScheduleNextPoll(ctx, orderNumber, claimedBy, delay)
+}
+
+func (s *OrderService) PostponePoll(ctx context.Context, orderNumber string, claimedBy string, delay time.Duration) error {
+	return s.orderRepository.PostponePoll(ctx, orderNumber, claimedBy, delay)
+}
+
+func (s *OrderService) ReleaseOrder(ctx context.Context, orderNumber string, claimedBy string) error {
+	return s.orderRepository.ReleaseOrder(ctx, orderNumber, claimedBy)
+}
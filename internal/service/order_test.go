@@ -0,0 +1,346 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"slices"
+	"testing"
+	"time"
+)
+
+type orderServiceFixture struct {
+	service     *OrderService
+	database    *sql.DB
+	orders      *repository.OrderRepository
+	events      *repository.AccrualEventRepository
+	ledger      *repository.LedgerRepository
+	userID      int
+	orderNumber string
+}
+
+func newOrderServiceFixture(t *testing.T) *orderServiceFixture {
+	t.Helper()
+
+	database := openTestDB(t)
+	f := &orderServiceFixture{
+		database:    database,
+		orders:      repository.NewOrderRepository(database),
+		events:      repository.NewAccrualEventRepository(database),
+		ledger:      repository.NewLedgerRepository(database),
+		userID:      createTestUser(t, database),
+		orderNumber: unique(),
+	}
+	f.service = NewOrderService(db.NewTransactionManager(database), f.orders, repository.NewBalanceRepository(database), f.ledger, f.events)
+
+	if err := f.orders.CreateOrder(context.Background(), f.orderNumber, f.userID); err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+
+	return f
+}
+
+func (f *orderServiceFixture) record(t *testing.T, events ...model.AccrualEvent) []int64 {
+	t.Helper()
+
+	for i := range events {
+		events[i].OrderNumber = f.orderNumber
+		events[i].Source = model.PollSource
+	}
+
+	recorded, err := f.service.RecordAccrualEvents(context.Background(), events)
+	if err != nil {
+		t.Fatalf("RecordAccrualEvents() error = %v", err)
+	}
+
+	ids := make([]int64, len(recorded))
+	for i, event := range recorded {
+		ids[i] = event.ID
+	}
+
+	return ids
+}
+
+// outcomes returns the outcomes of the events of the order in the order they were received, empty for unapplied ones.
+func (f *orderServiceFixture) outcomes(t *testing.T) []model.AccrualEventOutcome {
+	t.Helper()
+
+	rows, err := f.database.QueryContext(context.Background(),
+		`SELECT COALESCE(outcome, '') FROM accrual_events WHERE order_number = $1 ORDER BY id`,
+		f.orderNumber,
+	)
+	if err != nil {
+		t.Fatalf("query accrual events: %v", err)
+	}
+	defer rows.Close()
+
+	var outcomes []model.AccrualEventOutcome
+	for rows.Next() {
+		var outcome model.AccrualEventOutcome
+		if err := rows.Scan(&outcome); err != nil {
+			t.Fatalf("scan accrual event: %v", err)
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("query accrual events: %v", err)
+	}
+
+	return outcomes
+}
+
+// assertHistory checks the statuses the order went through, oldest first.
+func (f *orderServiceFixture) assertHistory(t *testing.T, want ...model.OrderStatus) {
+	t.Helper()
+
+	order, err := f.orders.GetOrder(context.Background(), f.orderNumber)
+	if err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+
+	history, err := f.orders.GetStatusHistory(context.Background(), order.ID)
+	if err != nil {
+		t.Fatalf("GetStatusHistory() error = %v", err)
+	}
+
+	statuses := make([]model.OrderStatus, len(history))
+	for i, change := range history {
+		statuses[i] = change.Status
+	}
+	if !slices.Equal(statuses, want) {
+		t.Errorf("status history = %v, want %v", statuses, want)
+	}
+}
+
+func (f *orderServiceFixture) assertOrder(t *testing.T, status model.OrderStatus, accrual model.Amount) {
+	t.Helper()
+
+	order, err := f.orders.GetOrder(context.Background(), f.orderNumber)
+	if err != nil {
+		t.Fatalf("GetOrder() error = %v", err)
+	}
+	if order.Status != status || order.Accrual != accrual {
+		t.Errorf("order is %s with accrual %s, want %s with %s", order.Status, order.Accrual, status, accrual)
+	}
+
+	balance, err := f.ledger.ReadBalanceByUserID(context.Background(), f.userID)
+	if err != nil {
+		t.Fatalf("ReadBalanceByUserID() error = %v", err)
+	}
+	if balance.Current != accrual {
+		t.Errorf("balance = %s, want %s", balance.Current, accrual)
+	}
+}
+
+func assertOutcomes(t *testing.T, got []model.AccrualEventOutcome, want ...model.AccrualEventOutcome) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("outcomes = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("outcomes = %v, want %v", got, want)
+			return
+		}
+	}
+}
+
+func TestApplyAccrualEventsOfOneOrder(t *testing.T) {
+	f := newOrderServiceFixture(t)
+
+	ids := f.record(t,
+		model.AccrualEvent{Status: string(model.Processing)},
+		model.AccrualEvent{Status: string(model.Processed), Accrual: 500},
+		model.AccrualEvent{Status: string(model.Processed), Accrual: 500},
+	)
+
+	rejected, err := f.service.ApplyAccrualEvents(context.Background(), ids, "")
+	if err != nil {
+		t.Fatalf("ApplyAccrualEvents() error = %v", err)
+	}
+
+	// Each event gets its own outcome, the last one decides what is reported for the order
+	assertOutcomes(t, f.outcomes(t), model.EventApplied, model.EventApplied, model.EventUnchanged)
+	if err := rejected[f.orderNumber]; !errors.Is(err, repository.ErrOrderStatusUnchanged) {
+		t.Errorf("rejected = %v, want %v", err, repository.ErrOrderStatusUnchanged)
+	}
+	f.assertOrder(t, model.Processed, 500)
+	f.assertHistory(t, model.New, model.Processing, model.Processed)
+
+	// Applied events are not applied again
+	rejected, err = f.service.ApplyAccrualEvents(context.Background(), ids, "")
+	if err != nil || len(rejected) != 0 {
+		t.Errorf("second ApplyAccrualEvents() = %v, %v, want nothing applied", rejected, err)
+	}
+	f.assertOrder(t, model.Processed, 500)
+}
+
+func TestApplyAccrualEventsRejectsIllegalTransition(t *testing.T) {
+	f := newOrderServiceFixture(t)
+
+	ids := f.record(t,
+		model.AccrualEvent{Status: string(model.Processed), Accrual: 100},
+		model.AccrualEvent{Status: string(model.Invalid)},
+	)
+
+	rejected, err := f.service.ApplyAccrualEvents(context.Background(), ids, "")
+	if err != nil {
+		t.Fatalf("ApplyAccrualEvents() error = %v", err)
+	}
+
+	assertOutcomes(t, f.outcomes(t), model.EventApplied, model.EventRejected)
+	if err := rejected[f.orderNumber]; !errors.Is(err, repository.ErrIllegalStatusTransition) {
+		t.Errorf("rejected = %v, want %v", err, repository.ErrIllegalStatusTransition)
+	}
+	f.assertOrder(t, model.Processed, 100)
+}
+
+func TestApplyAccrualEventsOfLostLease(t *testing.T) {
+	f := newOrderServiceFixture(t)
+
+	_, err := f.database.Exec(`UPDATE orders SET claimed_by = 'other', lease_until = now() + interval '1 minute' WHERE number = $1`, f.orderNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := f.record(t, model.AccrualEvent{Status: string(model.Processed), Accrual: 100})
+
+	rejected, err := f.service.ApplyAccrualEvents(context.Background(), ids, "test")
+	if err != nil {
+		t.Fatalf("ApplyAccrualEvents() error = %v", err)
+	}
+
+	if err := rejected[f.orderNumber]; !errors.Is(err, repository.ErrOrderLeaseLost) {
+		t.Errorf("rejected = %v, want %v", err, repository.ErrOrderLeaseLost)
+	}
+	assertOutcomes(t, f.outcomes(t), model.EventRejected)
+	f.assertOrder(t, model.New, 0)
+}
+
+func TestReplayAccrualEvents(t *testing.T) {
+	f := newOrderServiceFixture(t)
+
+	// Recorded, but the process stopped before applying it
+	f.record(t,
+		model.AccrualEvent{Status: string(model.Processing)},
+		model.AccrualEvent{Status: string(model.Processed), Accrual: 300},
+	)
+
+	// Recent events may still be applied by whoever recorded them
+	if _, err := f.service.ReplayAccrualEvents(context.Background(), time.Hour, 1000); err != nil {
+		t.Fatalf("ReplayAccrualEvents() error = %v", err)
+	}
+	assertOutcomes(t, f.outcomes(t), "", "")
+
+	time.Sleep(10 * time.Millisecond)
+
+	replayed, err := f.service.ReplayAccrualEvents(context.Background(), time.Millisecond, 1000)
+	if err != nil {
+		t.Fatalf("ReplayAccrualEvents() error = %v", err)
+	}
+	if replayed < 2 {
+		t.Errorf("ReplayAccrualEvents() = %d, want at least 2", replayed)
+	}
+
+	assertOutcomes(t, f.outcomes(t), model.EventApplied, model.EventApplied)
+	f.assertOrder(t, model.Processed, 300)
+}
+
+func TestPruneAccrualEvents(t *testing.T) {
+	f := newOrderServiceFixture(t)
+
+	ids := f.record(t,
+		model.AccrualEvent{Status: "REGISTERED"},
+		model.AccrualEvent{Status: string(model.Processing)},
+		model.AccrualEvent{Status: string(model.Processing)},
+	)
+
+	_, err := f.service.ApplyAccrualEvents(context.Background(), ids, "")
+	if err != nil {
+		t.Fatalf("ApplyAccrualEvents() error = %v", err)
+	}
+	assertOutcomes(t, f.outcomes(t), model.EventIgnored, model.EventApplied, model.EventUnchanged)
+
+	if _, err := f.service.PruneAccrualEvents(context.Background(), time.Hour); err != nil {
+		t.Fatalf("PruneAccrualEvents() error = %v", err)
+	}
+	assertOutcomes(t, f.outcomes(t), model.EventIgnored, model.EventApplied, model.EventUnchanged)
+
+	time.Sleep(10 * time.Millisecond)
+
+	// Only the event that changed the order is kept
+	if _, err := f.service.PruneAccrualEvents(context.Background(), time.Millisecond); err != nil {
+		t.Fatalf("PruneAccrualEvents() error = %v", err)
+	}
+	assertOutcomes(t, f.outcomes(t), model.EventApplied)
+}
+
+func TestCreateOrdersWithRepeatedNumber(t *testing.T) {
+	database := openTestDB(t)
+	userID := createTestUser(t, database)
+	orders := repository.NewOrderRepository(database)
+	service := NewOrderService(db.NewTransactionManager(database), orders, repository.NewBalanceRepository(database),
+		repository.NewLedgerRepository(database), repository.NewAccrualEventRepository(database))
+
+	// Order numbers are unique across the database, so the test makes a fresh one that passes the Luhn check
+	number := luhnNumber(unique())
+
+	uploads, err := service.CreateOrders(context.Background(), []string{number, "12345678904", number}, userID)
+	if err != nil {
+		t.Fatalf("CreateOrders() error = %v", err)
+	}
+
+	want := []model.OrderUploadResult{model.UploadAccepted, model.UploadInvalid, model.UploadAlreadyUploaded}
+	for i, upload := range uploads {
+		if upload.Result != want[i] {
+			t.Errorf("upload %d of %s = %s, want %s", i, upload.Number, upload.Result, want[i])
+		}
+	}
+}
+
+// luhnNumber appends the Luhn check digit to digits.
+func luhnNumber(digits string) string {
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		digit := int(digits[i] - '0')
+		if (len(digits)-i)%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+
+	return digits + string(rune('0'+(10-sum%10)%10))
+}
+
+func TestGetOrdersWithoutLimit(t *testing.T) {
+	f := newOrderServiceFixture(t)
+	for i := 0; i < 2; i++ {
+		if err := f.orders.CreateOrder(context.Background(), unique(), f.userID); err != nil {
+			t.Fatalf("create order: %v", err)
+		}
+	}
+
+	// Without a limit the whole list is one page
+	orders, next, err := f.service.GetOrders(context.Background(), f.userID, model.OrderQuery{PageQuery: model.PageQuery{Sort: model.SortDescending}})
+	if err != nil {
+		t.Fatalf("GetOrders() error = %v", err)
+	}
+	if len(orders) != 3 || next != nil {
+		t.Errorf("GetOrders() = %d orders, next %v, want 3 orders and no next page", len(orders), next)
+	}
+
+	orders, next, err = f.service.GetOrders(context.Background(), f.userID, model.OrderQuery{PageQuery: model.PageQuery{Limit: 2, Sort: model.SortDescending}})
+	if err != nil {
+		t.Fatalf("GetOrders() error = %v", err)
+	}
+	if len(orders) != 2 || next == nil {
+		t.Errorf("GetOrders() = %d orders, next %v, want 2 orders and a next page", len(orders), next)
+	}
+}
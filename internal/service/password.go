@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"github.com/zavtra-na-rabotu/gophermart/internal/notification"
+	"github.com/zavtra-na-rabotu/gophermart/internal/security"
+	"go.uber.org/zap"
+	"time"
+)
+
+var (
+	ErrIncorrectPassword         = errors.New("incorrect password")
+	ErrInvalidPasswordResetToken = errors.New("invalid password reset token")
+)
+
+type PasswordService struct {
+	transactionManager      *db.TransactionManager
+	userRepository          *repository.UserRepository
+	tokenRepository         *repository.TokenRepository
+	passwordResetRepository *repository.PasswordResetRepository
+	loginThrottle           *LoginThrottle
+	resetThrottle           *LoginThrottle
+	passwords               *security.Passwords
+	notifier                notification.Notifier
+	resetTokenLifetime      time.Duration
+}
+
+func NewPasswordService(
+	transactionManager *db.TransactionManager,
+	userRepository *repository.UserRepository,
+	tokenRepository *repository.TokenRepository,
+	passwordResetRepository *repository.PasswordResetRepository,
+	loginThrottle *LoginThrottle,
+	resetThrottle *LoginThrottle,
+	passwords *security.Passwords,
+	notifier notification.Notifier,
+	resetTokenLifetime time.Duration,
+) *PasswordService {
+	return &PasswordService{
+		transactionManager:      transactionManager,
+		userRepository:          userRepository,
+		tokenRepository:         tokenRepository,
+		passwordResetRepository: passwordResetRepository,
+		loginThrottle:           loginThrottle,
+		resetThrottle:           resetThrottle,
+		passwords:               passwords,
+		notifier:                notifier,
+		resetTokenLifetime:      resetTokenLifetime,
+	}
+}
+
+// ChangePassword sets a new password after checking the current one. Wrong current passwords count towards
+// the login limits, so a stolen access token can not be used to guess the password.
+func (s *PasswordService) ChangePassword(ctx context.Context, userID int, currentPassword string, newPassword string) error {
+	user, err := s.userRepository.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	keys := s.loginThrottle.keys(user.Login, "")
+
+	err = s.loginThrottle.Reserve(ctx, keys)
+	if err != nil {
+		return err
+	}
+
+	ok, _, err := s.passwords.Verify(user.Password, currentPassword)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		zap.L().Info("Invalid current password", zap.Int("userID", userID))
+		return ErrIncorrectPassword
+	}
+
+	hash, err := s.passwords.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	// The current password was verified against user.Password, so the change only goes through if that hash is still
+	// the stored one. Otherwise a reset or another change committed meanwhile would be silently overwritten.
+	_, err = s.transactionManager.RunInTransaction(ctx, func(tx *sql.Tx) (any, error) {
+		currentHash, err := s.userRepository.GetPasswordForUpdate(ctx, tx, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if currentHash != user.Password {
+			return nil, ErrPasswordChanged
+		}
+
+		return nil, s.setPassword(ctx, tx, userID, hash)
+	})
+	if err != nil {
+		if errors.Is(err, ErrPasswordChanged) {
+			zap.L().Info("Password changed during password change", zap.Int("userID", userID))
+			return ErrIncorrectPassword
+		}
+		return err
+	}
+
+	s.resetLoginAttempts(ctx, user.Login)
+	return nil
+}
+
+// RequestPasswordReset sends a single-use reset token to the user. Unknown logins are not reported,
+// so the endpoint can not be used to find out which accounts exist. Requests are limited per login and per
+// clientIP by a throttle of their own, so the endpoint can not be used to flood a user with reset messages
+// and never affects the login limits.
+func (s *PasswordService) RequestPasswordReset(ctx context.Context, login string, clientIP string) error {
+	err := s.resetThrottle.Reserve(ctx, s.resetThrottle.keys(login, clientIP))
+	if err != nil {
+		if errors.Is(err, ErrTooManyLoginAttempts) {
+			zap.L().Info("Password reset throttled", zap.String("login", login), zap.String("ip", clientIP))
+		}
+		return err
+	}
+
+	user, err := s.userRepository.GetUserByLogin(ctx, login)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			zap.L().Info("Password reset requested for unknown user", zap.String("login", login))
+			return nil
+		}
+		return err
+	}
+
+	token, tokenHash, err := security.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(s.resetTokenLifetime)
+
+	_, err = s.transactionManager.RunInTransaction(ctx, func(tx *sql.Tx) (any, error) {
+		return nil, s.passwordResetRepository.CreateResetToken(ctx, tx, &model.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: tokenHash,
+			ExpiresAt: expiresAt,
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.notifier.SendPasswordReset(ctx, notification.PasswordReset{Login: user.Login, Token: token, ExpiresAt: expiresAt})
+}
+
+// ConfirmPasswordReset sets a new password with a reset token. The token can only be used once.
+func (s *PasswordService) ConfirmPasswordReset(ctx context.Context, token string, newPassword string) error {
+	hash, err := s.passwords.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	userID, err := s.transactionManager.RunInTransaction(ctx, func(tx *sql.Tx) (any, error) {
+		resetToken, err := s.passwordResetRepository.GetResetTokenForUpdate(ctx, tx, security.HashOpaqueToken(token))
+		if err != nil {
+			if errors.Is(err, repository.ErrPasswordResetTokenNotFound) {
+				return nil, ErrInvalidPasswordResetToken
+			}
+			return nil, err
+		}
+
+		if resetToken.UsedAt != nil || time.Now().After(resetToken.ExpiresAt) {
+			return nil, ErrInvalidPasswordResetToken
+		}
+
+		return resetToken.UserID, s.setPassword(ctx, tx, resetToken.UserID, hash)
+	})
+	if err != nil {
+		return err
+	}
+
+	user, err := s.userRepository.GetUserByID(ctx, userID.(int))
+	if err != nil {
+		zap.L().Error("Failed to get user after password reset", zap.Error(err))
+		return nil
+	}
+
+	// The user has proven to own the account, so a lockout caused by someone else should not keep them out
+	s.resetLoginAttempts(ctx, user.Login)
+	return nil
+}
+
+// setPassword stores the new password hash and invalidates everything issued before: access tokens
+// through the token version, refresh tokens and pending reset tokens.
+func (s *PasswordService) setPassword(ctx context.Context, tx *sql.Tx, userID int, hash string) error {
+	err := s.userRepository.UpdatePassword(ctx, tx, userID, hash)
+	if err != nil {
+		return err
+	}
+
+	err = s.tokenRepository.RevokeUserRefreshTokens(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.passwordResetRepository.InvalidateResetTokens(ctx, tx, userID)
+}
+
+func (s *PasswordService) resetLoginAttempts(ctx context.Context, login string) {
+	err := s.loginThrottle.RecordSuccess(ctx, s.loginThrottle.keys(login, ""))
+	if err != nil {
+		zap.L().Error("Failed to reset login attempts", zap.Error(err))
+	}
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"github.com/zavtra-na-rabotu/gophermart/internal/security"
+	"go.uber.org/zap"
+	"time"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+	ErrPasswordChanged     = errors.New("password changed")
+)
+
+type TokenService struct {
+	transactionManager   *db.TransactionManager
+	tokenRepository      *repository.TokenRepository
+	userRepository       *repository.UserRepository
+	jwtService           *security.JwtService
+	refreshTokenLifetime time.Duration
+}
+
+func NewTokenService(
+	transactionManager *db.TransactionManager,
+	tokenRepository *repository.TokenRepository,
+	userRepository *repository.UserRepository,
+	jwtService *security.JwtService,
+	refreshTokenLifetime time.Duration,
+) *TokenService {
+	return &TokenService{
+		transactionManager:   transactionManager,
+		tokenRepository:      tokenRepository,
+		userRepository:       userRepository,
+		jwtService:           jwtService,
+		refreshTokenLifetime: refreshTokenLifetime,
+	}
+}
+
+// IssueTokens starts a new refresh token family for the user, as on login.
+func (s *TokenService) IssueTokens(ctx context.Context, userID int) (*model.TokenPair, error) {
+	familyID, err := security.GenerateID()
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := s.transactionManager.RunInTransaction(ctx, func(tx *sql.Tx) (any, error) {
+		return s.issueTokens(ctx, tx, userID, familyID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pair.(*model.TokenPair), nil
+}
+
+// IssueTokensForPassword is IssueTokens for a user who has just proven to know the password with the given hash.
+// If the password has been changed since, ErrPasswordChanged is returned: the tokens would carry the token version
+// of the new password. The hash is locked until the tokens are issued, so a change can not slip in between.
+func (s *TokenService) IssueTokensForPassword(ctx context.Context, userID int, passwordHash string) (*model.TokenPair, error) {
+	familyID, err := security.GenerateID()
+	if err != nil {
+		return nil, err
+	}
+
+	pair, err := s.transactionManager.RunInTransaction(ctx, func(tx *sql.Tx) (any, error) {
+		currentHash, err := s.userRepository.GetPasswordForShare(ctx, tx, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		if currentHash != passwordHash {
+			return nil, ErrPasswordChanged
+		}
+
+		return s.issueTokens(ctx, tx, userID, familyID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pair.(*model.TokenPair), nil
+}
+
+// Refresh exchanges a refresh token for a new pair. Every refresh token can be used once: presenting a used
+// or revoked one means it has leaked, so the whole family is revoked and ErrRefreshTokenReused is returned.
+func (s *TokenService) Refresh(ctx context.Context, refreshToken string) (*model.TokenPair, error) {
+	var reused bool
+
+	pair, err := s.transactionManager.RunInTransaction(ctx, func(tx *sql.Tx) (any, error) {
+		token, err := s.tokenRepository.GetRefreshTokenForUpdate(ctx, tx, security.HashOpaqueToken(refreshToken))
+		if err != nil {
+			if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+				return nil, ErrInvalidRefreshToken
+			}
+			return nil, err
+		}
+
+		if token.UsedAt != nil || token.RevokedAt != nil {
+			// The revocation has to be committed, so this is not returned as an error of the transaction
+			reused = true
+			return nil, s.tokenRepository.RevokeRefreshTokenFamily(ctx, tx, token.FamilyID)
+		}
+
+		if time.Now().After(token.ExpiresAt) {
+			return nil, ErrInvalidRefreshToken
+		}
+
+		err = s.tokenRepository.MarkRefreshTokenUsed(ctx, tx, token.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return s.issueTokens(ctx, tx, token.UserID, token.FamilyID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if reused {
+		zap.L().Warn("Refresh token reused, token family revoked")
+		return nil, ErrRefreshTokenReused
+	}
+
+	return pair.(*model.TokenPair), nil
+}
+
+// Logout revokes the access token the request was made with and, if given, the family of the refresh token.
+func (s *TokenService) Logout(ctx context.Context, claims *security.CustomClaims, refreshToken string) error {
+	_, err := s.transactionManager.RunInTransaction(ctx, func(tx *sql.Tx) (any, error) {
+		err := s.tokenRepository.RevokeAccessToken(ctx, tx, claims.ID, claims.ExpiresAt.Time)
+		if err != nil {
+			return nil, err
+		}
+
+		if refreshToken == "" {
+			return nil, nil
+		}
+
+		token, err := s.tokenRepository.GetRefreshTokenForUpdate(ctx, tx, security.HashOpaqueToken(refreshToken))
+		if err != nil {
+			if errors.Is(err, repository.ErrRefreshTokenNotFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		if token.UserID != claims.UserID {
+			return nil, nil
+		}
+
+		return nil, s.tokenRepository.RevokeRefreshTokenFamily(ctx, tx, token.FamilyID)
+	})
+
+	return err
+}
+
+// IsRevoked reports whether an access token is on the denylist or has been invalidated by a password change.
+func (s *TokenService) IsRevoked(ctx context.Context, claims *security.CustomClaims) (bool, error) {
+	return s.tokenRepository.IsAccessTokenRevoked(ctx, claims.ID, claims.UserID, claims.TokenVersion)
+}
+
+// PruneExpiredTokens removes expired refresh tokens and denylist entries. It returns the number of rows removed.
+func (s *TokenService) PruneExpiredTokens(ctx context.Context) (int64, error) {
+	refreshTokens, err := s.tokenRepository.DeleteExpiredRefreshTokens(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	accessTokens, err := s.tokenRepository.DeleteExpiredRevokedAccessTokens(ctx)
+	if err != nil {
+		return refreshTokens, err
+	}
+
+	return refreshTokens + accessTokens, nil
+}
+
+func (s *TokenService) issueTokens(ctx context.Context, tx *sql.Tx, userID int, familyID string) (*model.TokenPair, error) {
+	tokenVersion, err := s.userRepository.GetTokenVersion(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, claims, err := s.jwtService.GenerateJwtToken(userID, tokenVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, refreshTokenHash, err := security.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.tokenRepository.CreateRefreshToken(ctx, tx, &model.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: refreshTokenHash,
+		ExpiresAt: time.Now().Add(s.refreshTokenLifetime),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.TokenPair{
+		AccessToken:          accessToken,
+		AccessTokenExpiresAt: claims.ExpiresAt.Time,
+		RefreshToken:         refreshToken,
+	}, nil
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db"
+	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
+	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"github.com/zavtra-na-rabotu/gophermart/internal/security"
+	"testing"
+	"time"
+)
+
+func newTestTokenService(database *sql.DB, accessLifetime time.Duration, refreshLifetime time.Duration) (*TokenService, *security.JwtService) {
+	jwtService := security.NewJwtService([]byte("test-secret"), accessLifetime)
+	tokenService := NewTokenService(
+		db.NewTransactionManager(database),
+		repository.NewTokenRepository(database),
+		repository.NewUserRepository(database),
+		jwtService,
+		refreshLifetime,
+	)
+
+	return tokenService, jwtService
+}
+
+func issueTestTokens(t *testing.T, service *TokenService, userID int) *model.TokenPair {
+	t.Helper()
+
+	pair, err := service.IssueTokens(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("IssueTokens() error = %v", err)
+	}
+
+	return pair
+}
+
+func TestIssueTokensForPassword(t *testing.T) {
+	database := openTestDB(t)
+	service, _ := newTestTokenService(database, time.Minute, time.Hour)
+	// createTestUser stores "hash" as the password hash
+	userID := createTestUser(t, database)
+
+	if _, err := service.IssueTokensForPassword(context.Background(), userID, "hash"); err != nil {
+		t.Errorf("IssueTokensForPassword() error = %v", err)
+	}
+
+	if _, err := service.IssueTokensForPassword(context.Background(), userID, "old-hash"); !errors.Is(err, ErrPasswordChanged) {
+		t.Errorf("IssueTokensForPassword() of a changed password error = %v, want %v", err, ErrPasswordChanged)
+	}
+}
+
+func TestRefreshRotatesTokens(t *testing.T) {
+	database := openTestDB(t)
+	service, _ := newTestTokenService(database, time.Minute, time.Hour)
+	first := issueTestTokens(t, service, createTestUser(t, database))
+
+	second, err := service.Refresh(context.Background(), first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Error("Refresh() returned the tokens it was given")
+	}
+
+	if _, err := service.Refresh(context.Background(), second.RefreshToken); err != nil {
+		t.Errorf("Refresh() of the rotated token error = %v", err)
+	}
+
+	if _, err := service.Refresh(context.Background(), "unknown"); !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Refresh() of an unknown token error = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	database := openTestDB(t)
+	service, _ := newTestTokenService(database, time.Minute, time.Hour)
+	userID := createTestUser(t, database)
+	first := issueTestTokens(t, service, userID)
+	other := issueTestTokens(t, service, userID)
+
+	second, err := service.Refresh(context.Background(), first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	if _, err := service.Refresh(context.Background(), first.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("Refresh() of a used token error = %v, want %v", err, ErrRefreshTokenReused)
+	}
+
+	// The token rotated from the leaked one is revoked with it, another login of the user is not
+	if _, err := service.Refresh(context.Background(), second.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("Refresh() of a token of the revoked family error = %v, want %v", err, ErrRefreshTokenReused)
+	}
+	if _, err := service.Refresh(context.Background(), other.RefreshToken); err != nil {
+		t.Errorf("Refresh() of a token of another family error = %v", err)
+	}
+}
+
+func TestLogout(t *testing.T) {
+	database := openTestDB(t)
+	service, jwtService := newTestTokenService(database, time.Minute, time.Hour)
+	userID := createTestUser(t, database)
+	pair := issueTestTokens(t, service, userID)
+	stranger := issueTestTokens(t, service, createTestUser(t, database))
+
+	claims, err := jwtService.ValidateJwtToken(pair.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if revoked, err := service.IsRevoked(context.Background(), claims); err != nil || revoked {
+		t.Fatalf("IsRevoked() before logout = %v, %v", revoked, err)
+	}
+
+	// A refresh token of another user is left alone
+	if err := service.Logout(context.Background(), claims, stranger.RefreshToken); err != nil {
+		t.Fatalf("Logout() error = %v", err)
+	}
+	if _, err := service.Refresh(context.Background(), stranger.RefreshToken); err != nil {
+		t.Errorf("Refresh() of another user's token after logout error = %v", err)
+	}
+
+	if err := service.Logout(context.Background(), claims, pair.RefreshToken); err != nil {
+		t.Fatalf("Logout() error = %v", err)
+	}
+
+	if revoked, err := service.IsRevoked(context.Background(), claims); err != nil || !revoked {
+		t.Errorf("IsRevoked() after logout = %v, %v, want true", revoked, err)
+	}
+	if _, err := service.Refresh(context.Background(), pair.RefreshToken); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Errorf("Refresh() after logout error = %v, want %v", err, ErrRefreshTokenReused)
+	}
+}
+
+func TestPruneExpiredTokens(t *testing.T) {
+	database := openTestDB(t)
+	userID := createTestUser(t, database)
+
+	expired, expiredJwt := newTestTokenService(database, -time.Minute, -time.Minute)
+	expiredPair := issueTestTokens(t, expired, userID)
+
+	valid, _ := newTestTokenService(database, time.Minute, time.Hour)
+	validPair := issueTestTokens(t, valid, userID)
+
+	// Expired access tokens do not validate, so the claims are made by hand as the middleware would have read them
+	_, claims, err := expiredJwt.GenerateJwtToken(userID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expired.Logout(context.Background(), claims, ""); err != nil {
+		t.Fatalf("Logout() error = %v", err)
+	}
+
+	if _, err := valid.PruneExpiredTokens(context.Background()); err != nil {
+		t.Fatalf("PruneExpiredTokens() error = %v", err)
+	}
+
+	if _, err := valid.Refresh(context.Background(), validPair.RefreshToken); err != nil {
+		t.Errorf("Refresh() of a valid token after pruning error = %v", err)
+	}
+
+	var denylisted bool
+	err = database.QueryRow(`SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)`, claims.ID).Scan(&denylisted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if denylisted {
+		t.Error("denylist entry of an expired access token is not pruned")
+	}
+
+	var remaining int
+	err = database.QueryRow(`SELECT count(*) FROM refresh_tokens WHERE token_hash = $1`, security.HashOpaqueToken(expiredPair.RefreshToken)).Scan(&remaining)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != 0 {
+		t.Error("expired refresh token is not pruned")
+	}
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"github.com/zavtra-na-rabotu/gophermart/internal/db"
@@ -19,38 +20,44 @@ type UserService struct {
 	transactionManager *db.TransactionManager
 	userRepository     *repository.UserRepository
 	balanceRepository  *repository.BalanceRepository
-	jwtGenerator       *security.JwtService
+	tokenService       *TokenService
+	loginThrottle      *LoginThrottle
+	passwords          *security.Passwords
 }
 
 func NewUserService(
 	transactionManager *db.TransactionManager,
 	userRepository *repository.UserRepository,
 	balanceRepository *repository.BalanceRepository,
-	jwtGenerator *security.JwtService,
+	tokenService *TokenService,
+	loginThrottle *LoginThrottle,
+	passwords *security.Passwords,
 ) *UserService {
 	return &UserService{
 		transactionManager: transactionManager,
 		userRepository:     userRepository,
 		balanceRepository:  balanceRepository,
-		jwtGenerator:       jwtGenerator,
+		tokenService:       tokenService,
+		loginThrottle:      loginThrottle,
+		passwords:          passwords,
 	}
 }
 
-func (s *UserService) RegisterUser(request *dto.RegisterUserRequest) (string, error) {
-	hash, err := security.HashPassword(request.Password)
+func (s *UserService) RegisterUser(ctx context.Context, request *dto.RegisterUserRequest) (*model.TokenPair, error) {
+	hash, err := s.passwords.Hash(request.Password)
 	if err != nil {
 		zap.L().Error("Failed to hash password", zap.Error(err))
-		return "", err
+		return nil, err
 	}
 
-	user, err := s.transactionManager.RunInTransaction(func(tx *sql.Tx) (any, error) {
-		user, err := s.userRepository.CreateUser(tx, request.Login, hash)
+	user, err := s.transactionManager.RunInTransaction(ctx, func(tx *sql.Tx) (any, error) {
+		user, err := s.userRepository.CreateUser(ctx, tx, request.Login, hash)
 		if err != nil {
 			zap.L().Error("Failed to create user", zap.Error(err))
 			return nil, err
 		}
 
-		_, err = s.balanceRepository.CreateBalance(tx, user.ID)
+		_, err = s.balanceRepository.CreateBalance(ctx, tx, user.ID)
 		if err != nil {
 			zap.L().Error("Failed to create balance", zap.Error(err))
 			return nil, err
@@ -59,23 +66,82 @@ func (s *UserService) RegisterUser(request *dto.RegisterUserRequest) (string, er
 		return user, nil
 	})
 	if err != nil {
-		return "", nil
+		return nil, err
 	}
 
-	return s.jwtGenerator.GenerateJwtToken(user.(*model.User).ID)
+	return s.tokenService.IssueTokens(ctx, user.(*model.User).ID)
 }
 
-func (s *UserService) LoginUser(request *dto.LoginUserRequest) (string, error) {
-	user, err := s.userRepository.GetUserByLogin(request.Login)
+// LoginUser checks the credentials of a user logging in from clientIP. Unknown logins and wrong passwords
+// take the same time and count towards the same limits, so neither reveals whether an account exists.
+func (s *UserService) LoginUser(ctx context.Context, request *dto.LoginUserRequest, clientIP string) (*model.TokenPair, error) {
+	keys := s.loginThrottle.keys(request.Login, clientIP)
+
+	err := s.loginThrottle.Reserve(ctx, keys)
+	if err != nil {
+		if errors.Is(err, ErrTooManyLoginAttempts) {
+			zap.L().Info("Login throttled", zap.String("login", request.Login), zap.String("ip", clientIP))
+		}
+		return nil, err
+	}
+
+	user, err := s.userRepository.GetUserByLogin(ctx, request.Login)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			zap.L().Info("User not found", zap.String("login", request.Login))
+			s.passwords.VerifyDummy(request.Password)
+			return nil, ErrIncorrectLoginOrPassword
+		}
+		return nil, err
+	}
+
+	ok, rehash, err := s.passwords.Verify(user.Password, request.Password)
+	if err != nil {
+		zap.L().Error("Failed to verify password", zap.String("login", request.Login), zap.Error(err))
+		return nil, err
+	}
+
+	if !ok {
+		zap.L().Info("Invalid password", zap.String("login", request.Login))
+		return nil, ErrIncorrectLoginOrPassword
+	}
+
+	// The tokens are issued before the hash is replaced, as the hash tells that the password was not changed meanwhile
+	pair, err := s.tokenService.IssueTokensForPassword(ctx, user.ID, user.Password)
 	if err != nil {
-		zap.L().Error("User not found", zap.String("login", request.Login), zap.Error(err))
-		return "", ErrIncorrectLoginOrPassword
+		if errors.Is(err, ErrPasswordChanged) {
+			zap.L().Info("Password changed during login", zap.String("login", request.Login))
+			return nil, ErrIncorrectLoginOrPassword
+		}
+		return nil, err
+	}
+
+	err = s.loginThrottle.RecordSuccess(ctx, keys)
+	if err != nil {
+		zap.L().Error("Failed to reset login attempts", zap.Error(err))
+	}
+
+	if rehash {
+		s.rehashPassword(ctx, user, request.Password)
 	}
 
-	if !security.CheckPassword(user.Password, request.Password) {
-		zap.L().Error("Invalid password")
-		return "", ErrIncorrectLoginOrPassword
+	return pair, nil
+}
+
+// rehashPassword replaces a hash of an outdated algorithm or cost while the plain password is at hand.
+// A failure only delays the upgrade to the next login, so it does not fail the login.
+func (s *UserService) rehashPassword(ctx context.Context, user *model.User, password string) {
+	hash, err := s.passwords.Hash(password)
+	if err != nil {
+		zap.L().Error("Failed to rehash password", zap.Int("userID", user.ID), zap.Error(err))
+		return
+	}
+
+	err = s.userRepository.ReplacePasswordHash(ctx, user.ID, user.Password, hash)
+	if err != nil {
+		zap.L().Error("Failed to store rehashed password", zap.Int("userID", user.ID), zap.Error(err))
+		return
 	}
 
-	return s.jwtGenerator.GenerateJwtToken(user.ID)
+	zap.L().Info("Password rehashed", zap.Int("userID", user.ID))
 }
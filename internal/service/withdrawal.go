@@ -1,11 +1,13 @@
 package service
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"github.com/zavtra-na-rabotu/gophermart/internal/db"
 	"github.com/zavtra-na-rabotu/gophermart/internal/db/repository"
 	"github.com/zavtra-na-rabotu/gophermart/internal/model"
+	"github.com/zavtra-na-rabotu/gophermart/internal/utils/cursor"
 )
 
 var (
@@ -17,6 +19,7 @@ type WithdrawalService struct {
 	withdrawalRepository *repository.WithdrawalRepository
 	orderRepository      *repository.OrderRepository
 	balanceRepository    *repository.BalanceRepository
+	ledgerRepository     *repository.LedgerRepository
 }
 
 func NewWithdrawalService(
@@ -24,27 +27,29 @@ func NewWithdrawalService(
 	withdrawalRepository *repository.WithdrawalRepository,
 	orderRepository *repository.OrderRepository,
 	balanceRepository *repository.BalanceRepository,
+	ledgerRepository *repository.LedgerRepository,
 ) *WithdrawalService {
 	return &WithdrawalService{
 		transactionManager:   transactionManager,
 		withdrawalRepository: withdrawalRepository,
 		orderRepository:      orderRepository,
 		balanceRepository:    balanceRepository,
+		ledgerRepository:     ledgerRepository,
 	}
 }
 
-func (s *WithdrawalService) GetWithdrawals(userID int) ([]model.Withdrawal, error) {
-	withdrawals, err := s.withdrawalRepository.GetWithdrawals(userID)
+func (s *WithdrawalService) GetWithdrawals(ctx context.Context, userID int, page model.PageQuery) ([]model.Withdrawal, *cursor.Cursor, error) {
+	withdrawals, next, err := s.withdrawalRepository.GetWithdrawals(ctx, userID, page)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return withdrawals, nil
+	return withdrawals, next, nil
 }
 
-func (s *WithdrawalService) CreateWithdrawal(userID int, orderNumber string, sum float64) error {
-	_, err := s.transactionManager.RunInTransaction(func(tx *sql.Tx) (any, error) {
-		balance, err := s.balanceRepository.GetBalanceForUpdateByUserID(tx, userID)
+func (s *WithdrawalService) CreateWithdrawal(ctx context.Context, userID int, orderNumber string, sum model.Amount) error {
+	_, err := s.transactionManager.RunInTransaction(ctx, func(tx *sql.Tx) (any, error) {
+		balance, err := reconcileBalance(ctx, tx, s.balanceRepository, s.ledgerRepository, userID)
 		if err != nil {
 			return nil, err
 		}
@@ -53,17 +58,28 @@ func (s *WithdrawalService) CreateWithdrawal(userID int, orderNumber string, sum
 			return nil, ErrNotEnoughBalance
 		}
 
-		err = s.orderRepository.CreateOrderInTransaction(tx, orderNumber, userID)
+		err = s.orderRepository.CreateOrderInTransaction(ctx, tx, orderNumber, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		withdrawal, err := s.withdrawalRepository.CreateWithdrawal(ctx, tx, userID, orderNumber, sum)
 		if err != nil {
 			return nil, err
 		}
 
-		err = s.withdrawalRepository.CreateWithdrawal(tx, userID, orderNumber, sum)
+		_, err = s.ledgerRepository.CreateEntry(ctx, tx, &model.LedgerEntry{
+			UserID:       userID,
+			Type:         model.WithdrawalEntry,
+			Amount:       -sum,
+			OrderNumber:  &withdrawal.OrderNumber,
+			WithdrawalID: &withdrawal.ID,
+		})
 		if err != nil {
 			return nil, err
 		}
 
-		err = s.balanceRepository.WithdrawByUserID(tx, userID, sum)
+		err = s.balanceRepository.WithdrawByUserID(ctx, tx, userID, sum)
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,30 @@
+package backoff
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Exponential returns the delay before retry number attempt (starting from zero).
+// The delay doubles with every attempt up to max, and the upper half of it is randomized
+// so that retries of many items scheduled at the same moment spread out.
+func Exponential(attempt int, base time.Duration, max time.Duration) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+
+	delay := max
+	if attempt < 62 {
+		exp := base << attempt
+		if exp > 0 && exp < max {
+			delay = exp
+		}
+	}
+
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+
+	return half + rand.N(half+1)
+}
@@ -0,0 +1,31 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExponential(t *testing.T) {
+	tests := []struct {
+		name    string
+		attempt int
+		wantMin time.Duration
+		wantMax time.Duration
+	}{
+		{name: "First attempt", attempt: 0, wantMin: 500 * time.Millisecond, wantMax: time.Second},
+		{name: "Third attempt", attempt: 2, wantMin: 2 * time.Second, wantMax: 4 * time.Second},
+		{name: "Capped", attempt: 20, wantMin: 30 * time.Second, wantMax: time.Minute},
+		{name: "Overflow", attempt: 100, wantMin: 30 * time.Second, wantMax: time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				got := Exponential(test.attempt, time.Second, time.Minute)
+				if got < test.wantMin || got > test.wantMax {
+					t.Fatalf("Exponential() = %v, want between %v and %v", got, test.wantMin, test.wantMax)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+// Package cursor encodes keyset pagination positions into opaque URL-safe tokens.
+package cursor
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor points at the last row of a page: the next page starts right after (Time, ID) in the sort order.
+// Time is kept with microsecond precision, the same as PostgreSQL timestamps.
+type Cursor struct {
+	Time time.Time
+	ID   int
+}
+
+func New(t time.Time, id int) Cursor {
+	return Cursor{Time: t.Truncate(time.Microsecond), ID: id}
+}
+
+func (c Cursor) Encode() string {
+	raw := strconv.FormatInt(c.Time.UnixMicro(), 10) + ":" + strconv.Itoa(c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func Decode(token string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+
+	micros, id, found := strings.Cut(string(raw), ":")
+	if !found {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	timestamp, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+
+	rowID, err := strconv.Atoi(id)
+	if err != nil || rowID < 0 {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return Cursor{Time: time.UnixMicro(timestamp), ID: rowID}, nil
+}
@@ -0,0 +1,44 @@
+package cursor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	original := New(time.Date(2024, 7, 1, 12, 30, 45, 123456789, time.UTC), 42)
+
+	decoded, err := Decode(original.Encode())
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	if !decoded.Time.Equal(original.Time) || decoded.ID != original.ID {
+		t.Errorf("Decode() = %+v, want %+v", decoded, original)
+	}
+
+	if decoded.Time.Nanosecond() != 123456000 {
+		t.Errorf("Decode() time is not truncated to microseconds: %v", decoded.Time)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "Not base64", token: "%%%"},
+		{name: "No separator", token: "MTIz"},
+		{name: "Bad time", token: "YWJjOjE"},
+		{name: "Negative id", token: "MTIzOi0x"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Decode(test.token); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("Decode(%q) error = %v, want %v", test.token, err, ErrInvalidCursor)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package validation
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxPasswordBytes is the longest password bcrypt takes into account. Longer ones would be silently truncated.
+const MaxPasswordBytes = 72
+
+const (
+	fieldLogin    = "login"
+	fieldPassword = "password"
+)
+
+type UserPolicy struct {
+	LoginMinLength int
+	LoginMaxLength int
+	// LoginPattern is the set of allowed login characters as a regular expression, e.g. ^[a-z0-9_]+$.
+	LoginPattern string
+	// PasswordMinLength is counted in characters, the upper limit is MaxPasswordBytes.
+	PasswordMinLength int
+	// PasswordMinClasses is how many of lower case letters, upper case letters, digits and other characters
+	// a password must contain.
+	PasswordMinClasses int
+	// BreachedPasswordsFile is a file of known breached passwords, one per line, either in plain text
+	// or as SHA-1 hex digests with an optional ":count" suffix, as published by Have I Been Pwned. Empty to skip the check.
+	BreachedPasswordsFile string
+}
+
+type UserValidator struct {
+	policy       UserPolicy
+	loginPattern *regexp.Regexp
+	breached     map[[sha1.Size]byte]struct{}
+}
+
+func NewUserValidator(policy UserPolicy) (*UserValidator, error) {
+	validator := &UserValidator{policy: policy}
+
+	if policy.LoginPattern != "" {
+		pattern, err := regexp.Compile(policy.LoginPattern)
+		if err != nil {
+			return nil, fmt.Errorf("login pattern: %w", err)
+		}
+		validator.loginPattern = pattern
+	}
+
+	if policy.BreachedPasswordsFile != "" {
+		breached, err := loadBreachedPasswords(policy.BreachedPasswordsFile)
+		if err != nil {
+			return nil, fmt.Errorf("breached passwords: %w", err)
+		}
+		validator.breached = breached
+	}
+
+	return validator, nil
+}
+
+// ValidateRegistration checks the credentials of a new user against the policy. It returns Errors.
+func (v *UserValidator) ValidateRegistration(login string, password string) error {
+	var errs Errors
+	v.validateLogin(&errs, login)
+	v.validatePassword(&errs, login, password)
+	return errs.err()
+}
+
+// ValidatePassword checks a new password of an existing user against the policy. It returns Errors.
+func (v *UserValidator) ValidatePassword(password string) error {
+	var errs Errors
+	v.validatePassword(&errs, "", password)
+	return errs.err()
+}
+
+func (v *UserValidator) validateLogin(errs *Errors, login string) {
+	length := utf8.RuneCountInString(login)
+
+	switch {
+	case length == 0:
+		errs.add(fieldLogin, "is required")
+	case length < v.policy.LoginMinLength:
+		errs.add(fieldLogin, fmt.Sprintf("must be at least %d characters long", v.policy.LoginMinLength))
+	case v.policy.LoginMaxLength > 0 && length > v.policy.LoginMaxLength:
+		errs.add(fieldLogin, fmt.Sprintf("must be at most %d characters long", v.policy.LoginMaxLength))
+	case v.loginPattern != nil && !v.loginPattern.MatchString(login):
+		errs.add(fieldLogin, "contains characters that are not allowed")
+	}
+}
+
+func (v *UserValidator) validatePassword(errs *Errors, login string, password string) {
+	switch {
+	case password == "":
+		errs.add(fieldPassword, "is required")
+		return
+	case len(password) > MaxPasswordBytes:
+		errs.add(fieldPassword, fmt.Sprintf("must be at most %d bytes long", MaxPasswordBytes))
+		return
+	case utf8.RuneCountInString(password) < v.policy.PasswordMinLength:
+		errs.add(fieldPassword, fmt.Sprintf("must be at least %d characters long", v.policy.PasswordMinLength))
+		return
+	}
+
+	if characterClasses(password) < v.policy.PasswordMinClasses {
+		errs.add(fieldPassword, fmt.Sprintf(
+			"must contain at least %d of: lower case letters, upper case letters, digits, other characters",
+			v.policy.PasswordMinClasses,
+		))
+	}
+
+	if login != "" && strings.EqualFold(password, login) {
+		errs.add(fieldPassword, "must not be the same as the login")
+	}
+
+	if _, ok := v.breached[sha1.Sum([]byte(password))]; ok {
+		errs.add(fieldPassword, "has appeared in a data breach, choose another one")
+	}
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			classes++
+		}
+	}
+
+	return classes
+}
+
+// loadBreachedPasswords keeps SHA-1 digests only, so a large list takes a fixed 20 bytes per entry.
+func loadBreachedPasswords(path string) (map[[sha1.Size]byte]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	breached := make(map[[sha1.Size]byte]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		breached[breachedPasswordDigest(line)] = struct{}{}
+	}
+
+	return breached, scanner.Err()
+}
+
+func breachedPasswordDigest(line string) [sha1.Size]byte {
+	hash, _, _ := strings.Cut(line, ":")
+	if len(hash) == 2*sha1.Size {
+		var digest [sha1.Size]byte
+		if _, err := hex.Decode(digest[:], []byte(hash)); err == nil {
+			return digest
+		}
+	}
+
+	return sha1.Sum([]byte(line))
+}
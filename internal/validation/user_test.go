@@ -0,0 +1,104 @@
+package validation
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUserValidatorValidateRegistration(t *testing.T) {
+	breachedHash := sha1.Sum([]byte("Password1"))
+	breachedFile := filepath.Join(t.TempDir(), "breached.txt")
+	content := "qwerty123\n" + strings.ToUpper(hex.EncodeToString(breachedHash[:])) + ":3861493\n"
+	if err := os.WriteFile(breachedFile, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	validator, err := NewUserValidator(UserPolicy{
+		LoginMinLength:        3,
+		LoginMaxLength:        16,
+		LoginPattern:          `^[a-z0-9_]+$`,
+		PasswordMinLength:     8,
+		PasswordMinClasses:    2,
+		BreachedPasswordsFile: breachedFile,
+	})
+	if err != nil {
+		t.Fatalf("NewUserValidator() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		want     Errors
+	}{
+		{name: "Valid", login: "gopher", password: "correct-horse"},
+		{name: "Empty", login: "", password: "", want: Errors{
+			{Field: fieldLogin, Message: "is required"},
+			{Field: fieldPassword, Message: "is required"},
+		}},
+		{name: "Short login", login: "go", password: "correct-horse", want: Errors{
+			{Field: fieldLogin, Message: "must be at least 3 characters long"},
+		}},
+		{name: "Long login", login: strings.Repeat("a", 17), password: "correct-horse", want: Errors{
+			{Field: fieldLogin, Message: "must be at most 16 characters long"},
+		}},
+		{name: "Login charset", login: "go pher", password: "correct-horse", want: Errors{
+			{Field: fieldLogin, Message: "contains characters that are not allowed"},
+		}},
+		{name: "Short password", login: "gopher", password: "abc1", want: Errors{
+			{Field: fieldPassword, Message: "must be at least 8 characters long"},
+		}},
+		{name: "Password over bcrypt limit", login: "gopher", password: strings.Repeat("ab1", 25), want: Errors{
+			{Field: fieldPassword, Message: "must be at most 72 bytes long"},
+		}},
+		{name: "Single character class", login: "gopher", password: "abcdefghij", want: Errors{
+			{Field: fieldPassword, Message: "must contain at least 2 of: lower case letters, upper case letters, digits, other characters"},
+		}},
+		{name: "Password equals login", login: "gopher_1", password: "Gopher_1", want: Errors{
+			{Field: fieldPassword, Message: "must not be the same as the login"},
+		}},
+		{name: "Breached plain text", login: "gopher", password: "qwerty123", want: Errors{
+			{Field: fieldPassword, Message: "has appeared in a data breach, choose another one"},
+		}},
+		{name: "Breached SHA-1", login: "gopher", password: "Password1", want: Errors{
+			{Field: fieldPassword, Message: "has appeared in a data breach, choose another one"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validator.ValidateRegistration(test.login, test.password)
+			if test.want == nil {
+				if err != nil {
+					t.Fatalf("ValidateRegistration() error = %v, want nil", err)
+				}
+				return
+			}
+
+			var got Errors
+			if !errors.As(err, &got) || !errors.Is(err, ErrInvalid) {
+				t.Fatalf("ValidateRegistration() error = %v, want %v", err, test.want)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("ValidateRegistration() = %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Errorf("ValidateRegistration()[%d] = %v, want %v", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNewUserValidatorMissingBreachedFile(t *testing.T) {
+	_, err := NewUserValidator(UserPolicy{BreachedPasswordsFile: filepath.Join(t.TempDir(), "missing.txt")})
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("NewUserValidator() error = %v, want %v", err, os.ErrNotExist)
+	}
+}
@@ -0,0 +1,43 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalid = errors.New("validation failed")
+
+// FieldError is a problem with one field of a request.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+// Errors lists every problem found in a request, so the client can fix all of them at once. It matches ErrInvalid.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		messages[i] = fieldError.Field + ": " + fieldError.Message
+	}
+
+	return ErrInvalid.Error() + ": " + strings.Join(messages, "; ")
+}
+
+func (e Errors) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+func (e *Errors) add(field string, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// err returns nil when nothing was found, so the result can be returned as an error directly.
+func (e Errors) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e
+}